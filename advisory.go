@@ -0,0 +1,203 @@
+package flexpg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/lib/pq"
+)
+
+// ErrLockTimeout is returned when advisory lock is not acquired within its timeout
+var ErrLockTimeout = errors.New("advisory lock timeout")
+
+// AdvisoryKey returns key of advisory lock from a name, ie: AdvisoryKey("cron:daily-report")
+func AdvisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// AdvisoryLock is session advisory lock. It holds a connection of the pool until it is unlocked, so unlock is executed
+// on the same session that acquires it
+type AdvisoryLock struct {
+	Key  int64
+	conn *sql.Conn
+}
+
+// AdvisoryLock acquires session advisory lock, it waits until the lock is released by other session or ctx is done
+func (c *Connection) AdvisoryLock(ctx context.Context, key int64) (*AdvisoryLock, error) {
+	return c.advisoryLock(ctx, key, 0)
+}
+
+// AdvisoryLockTimeout acquires session advisory lock, ErrLockTimeout is returned when it is not acquired within timeout
+func (c *Connection) AdvisoryLockTimeout(ctx context.Context, key int64, timeout time.Duration) (*AdvisoryLock, error) {
+	if timeout <= 0 {
+		return nil, errors.New("timeout should be greater than zero")
+	}
+	return c.advisoryLock(ctx, key, timeout)
+}
+
+// TryAdvisoryLock acquires session advisory lock without waiting, it returns nil when the lock is held by other session
+func (c *Connection) TryAdvisoryLock(ctx context.Context, key int64) (*AdvisoryLock, error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acquired := false
+	cmdtxt := fmt.Sprintf("SELECT pg_try_advisory_lock(%d)", key)
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	if err = conn.QueryRowContext(ctx, cmdtxt).Scan(&acquired); err != nil || !acquired {
+		conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+		}
+		return nil, nil
+	}
+	return &AdvisoryLock{Key: key, conn: conn}, nil
+}
+
+func (c *Connection) advisoryLock(ctx context.Context, key int64, timeout time.Duration) (*AdvisoryLock, error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []string{fmt.Sprintf("SELECT pg_advisory_lock(%d)", key)}
+	if timeout > 0 {
+		// lock_timeout of the session is restored to its value before, not to the server default
+		previous := ""
+		if err = conn.QueryRowContext(ctx, currentLockTimeoutCommand).Scan(&previous); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error: %s command: %s", err.Error(), currentLockTimeoutCommand)
+		}
+		cmds = []string{
+			fmt.Sprintf("SET lock_timeout = %d", lockTimeoutMillis(timeout)),
+			cmds[0],
+			restoreLockTimeoutCommand(previous, false),
+		}
+	}
+	for _, cmdtxt := range cmds {
+		dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+		if _, err = conn.ExecContext(ctx, cmdtxt); err != nil {
+			// the connection is discarded instead of returned to the pool, so the lock is released in case it has been acquired
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			conn.Close()
+			if isLockNotAvailable(err) {
+				return nil, ErrLockTimeout
+			}
+			return nil, fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+		}
+	}
+	return &AdvisoryLock{Key: key, conn: conn}, nil
+}
+
+// Unlock releases the lock and returns its connection to the pool
+func (l *AdvisoryLock) Unlock() error {
+	if l == nil || l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Close()
+		l.conn = nil
+	}()
+
+	released := false
+	cmdtxt := fmt.Sprintf("SELECT pg_advisory_unlock(%d)", l.Key)
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	if err := l.conn.QueryRowContext(context.Background(), cmdtxt).Scan(&released); err != nil {
+		return fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+	if !released {
+		return fmt.Errorf("advisory lock %d is not held", l.Key)
+	}
+	return nil
+}
+
+// TxAdvisoryLock acquires transaction advisory lock, it is released when the transaction ends
+func (c *Connection) TxAdvisoryLock(key int64) error {
+	if !c.IsTx() {
+		return errors.New("transaction advisory lock should be used inside a transaction, call BeginTx first")
+	}
+	return c.execTx(fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", key))
+}
+
+// TxAdvisoryLockTimeout acquires transaction advisory lock, ErrLockTimeout is returned when it is not acquired
+// within timeout. Postgres aborts the transaction on timeout, so it should be rolled back
+func (c *Connection) TxAdvisoryLockTimeout(key int64, timeout time.Duration) error {
+	if !c.IsTx() {
+		return errors.New("transaction advisory lock should be used inside a transaction, call BeginTx first")
+	}
+	if timeout <= 0 {
+		return errors.New("timeout should be greater than zero")
+	}
+	previous := ""
+	dbflex.Logger().Debugf("execute command: %s", currentLockTimeoutCommand)
+	if err := c.tx.QueryRow(currentLockTimeoutCommand).Scan(&previous); err != nil {
+		return fmt.Errorf("error: %s command: %s", err.Error(), currentLockTimeoutCommand)
+	}
+	for _, cmdtxt := range []string{
+		fmt.Sprintf("SET LOCAL lock_timeout = %d", lockTimeoutMillis(timeout)),
+		fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", key),
+		restoreLockTimeoutCommand(previous, true),
+	} {
+		if err := c.execTx(cmdtxt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TryTxAdvisoryLock acquires transaction advisory lock without waiting, it returns false when the lock is held by
+// other session
+func (c *Connection) TryTxAdvisoryLock(key int64) (bool, error) {
+	if !c.IsTx() {
+		return false, errors.New("transaction advisory lock should be used inside a transaction, call BeginTx first")
+	}
+	acquired := false
+	cmdtxt := fmt.Sprintf("SELECT pg_try_advisory_xact_lock(%d)", key)
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	if err := c.tx.QueryRow(cmdtxt).Scan(&acquired); err != nil {
+		return false, fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+	return acquired, nil
+}
+
+func (c *Connection) execTx(cmdtxt string) error {
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	if _, err := c.tx.Exec(cmdtxt); err != nil {
+		if isLockNotAvailable(err) {
+			return ErrLockTimeout
+		}
+		return fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+	return nil
+}
+
+const currentLockTimeoutCommand = "SELECT current_setting('lock_timeout')"
+
+// lockTimeoutMillis returns timeout in milliseconds rounded up, since lock_timeout = 0 means waiting forever
+func lockTimeoutMillis(timeout time.Duration) int64 {
+	ms := int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
+
+// restoreLockTimeoutCommand returns command setting lock_timeout back to a value read by currentLockTimeoutCommand,
+// it only lasts until the transaction ends when isLocal is true
+func restoreLockTimeoutCommand(value string, isLocal bool) string {
+	return fmt.Sprintf("SELECT set_config('lock_timeout', '%s', %t)", CleanupSQL(value), isLocal)
+}
+
+// isLockNotAvailable returns true if error is lock_not_available, raised when lock_timeout passes
+func isLockNotAvailable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "55P03"
+}
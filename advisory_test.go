@@ -0,0 +1,35 @@
+package flexpg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdvisoryKey(t *testing.T) {
+	cv.Convey("advisory lock key", t, func() {
+		cv.So(AdvisoryKey("cron:daily-report"), cv.ShouldEqual, AdvisoryKey("cron:daily-report"))
+		cv.So(AdvisoryKey("cron:daily-report"), cv.ShouldNotEqual, AdvisoryKey("cron:weekly-report"))
+
+		cv.Convey("transaction lock requires transaction", func() {
+			c := new(Connection)
+			cv.So(c.TxAdvisoryLock(1), cv.ShouldNotBeNil)
+			_, err := c.TryTxAdvisoryLock(1)
+			cv.So(err, cv.ShouldNotBeNil)
+
+			cv.So(isLockNotAvailable(&pq.Error{Code: "55P03"}), cv.ShouldBeTrue)
+			cv.So(isLockNotAvailable(&pq.Error{Code: "40001"}), cv.ShouldBeFalse)
+
+			cv.So(lockTimeoutMillis(500*time.Microsecond), cv.ShouldEqual, 1)
+			cv.So(lockTimeoutMillis(time.Nanosecond), cv.ShouldEqual, 1)
+			cv.So(lockTimeoutMillis(1500*time.Microsecond), cv.ShouldEqual, 2)
+			cv.So(lockTimeoutMillis(time.Second), cv.ShouldEqual, 1000)
+			cv.So(restoreLockTimeoutCommand("5s", true), cv.ShouldEqual, "SELECT set_config('lock_timeout', '5s', true)")
+
+			var lock *AdvisoryLock
+			cv.So(lock.Unlock(), cv.ShouldBeNil)
+		})
+	})
+}
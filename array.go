@@ -0,0 +1,270 @@
+package flexpg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArrayValue wraps a Go slice to be written as PostgreSQL array instead of jsonb
+type ArrayValue struct {
+	Value interface{}
+}
+
+// Array marks a slice to be written as PostgreSQL array, ie: to be used on codekit.M data or array filters.
+// Struct fields tagged with db_array:"true" are written as array without it
+func Array(v interface{}) ArrayValue {
+	if array, ok := v.(ArrayValue); ok {
+		return array
+	}
+	return ArrayValue{Value: v}
+}
+
+// isArrayField returns true if a slice field is stored as native array column, tagged by db_array:"true"
+func isArrayField(f reflect.StructField) bool {
+	if f.Tag.Get("db_array") != "true" {
+		return false
+	}
+	t := indirectType(f.Type)
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8
+}
+
+// SQLValue returns array literal of the value, ie: '{"a","b"}'
+func (a ArrayValue) SQLValue() string {
+	rv := reflect.Indirect(reflect.ValueOf(a.Value))
+	if rv.Kind() == reflect.Invalid || (rv.Kind() == reflect.Slice && rv.IsNil()) {
+		return "NULL"
+	}
+	return fmt.Sprintf("'%s'", CleanupSQL(arrayLiteral(rv)))
+}
+
+func arrayLiteral(rv reflect.Value) string {
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return arrayElementLiteral(rv)
+	}
+	items := make([]string, rv.Len())
+	for i := range items {
+		item := rv.Index(i)
+		for (item.Kind() == reflect.Interface || item.Kind() == reflect.Ptr) && !item.IsNil() {
+			item = item.Elem()
+		}
+		switch item.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			items[i] = "NULL"
+		case reflect.Slice, reflect.Array:
+			items[i] = arrayLiteral(item)
+		default:
+			items[i] = arrayElementLiteral(item)
+		}
+	}
+	return "{" + strings.Join(items, ",") + "}"
+}
+
+func arrayElementLiteral(rv reflect.Value) string {
+	var str string
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.String:
+		str = rv.String()
+	default:
+		if dt, ok := rv.Interface().(time.Time); ok {
+			str = dt.Round(time.Microsecond).Format(timestampLayout)
+		} else if stringer, ok := rv.Interface().(fmt.Stringer); ok {
+			str = stringer.String()
+		} else {
+			bs, _ := json.Marshal(rv.Interface())
+			str = string(bs)
+		}
+	}
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	str = strings.ReplaceAll(str, `"`, `\"`)
+	return `"` + str + `"`
+}
+
+// parseArrayLiteral parses array output of PostgreSQL, ie: {a,"b c",NULL}, into its elements.
+// NULL element is returned as nil. Nested array is returned as []interface{}
+func parseArrayLiteral(s string) ([]interface{}, error) {
+	res, rest, err := parseArrayItems(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("invalid array literal: %s", s)
+	}
+	return res, nil
+}
+
+func parseArrayItems(s string) ([]interface{}, string, error) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, s, fmt.Errorf("invalid array literal: %s", s)
+	}
+	s = s[1:]
+	res := []interface{}{}
+	if strings.HasPrefix(s, "}") {
+		return res, s[1:], nil
+	}
+
+	for {
+		var (
+			item interface{}
+			err  error
+		)
+		switch {
+		case strings.HasPrefix(s, "{"):
+			item, s, err = parseArrayItems(s)
+			if err != nil {
+				return nil, s, err
+			}
+
+		case strings.HasPrefix(s, `"`):
+			sb := strings.Builder{}
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, s, errors.New("invalid array literal: unterminated quote")
+			}
+			item = sb.String()
+			s = s[i+1:]
+
+		default:
+			end := strings.IndexAny(s, ",}")
+			if end < 0 {
+				return nil, s, errors.New("invalid array literal: missing closing brace")
+			}
+			str := strings.TrimSpace(s[:end])
+			if str == "NULL" {
+				item = nil
+			} else {
+				item = str
+			}
+			s = s[end:]
+		}
+
+		res = append(res, item)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, "}"):
+			return res, s[1:], nil
+		default:
+			return nil, s, errors.New("invalid array literal: missing closing brace")
+		}
+	}
+}
+
+// castArray converts array output of PostgreSQL into a slice of refType
+func castArray(str string, refType reflect.Type) (interface{}, error) {
+	items, err := parseArrayLiteral(str)
+	if err != nil {
+		return nil, err
+	}
+	target, err := castArrayItems(items, indirectType(refType))
+	if err != nil {
+		return nil, err
+	}
+	if refType.Kind() == reflect.Ptr {
+		ptr := reflect.New(target.Type())
+		ptr.Elem().Set(target)
+		return ptr.Interface(), nil
+	}
+	return target.Interface(), nil
+}
+
+func castArrayItems(items []interface{}, sliceType reflect.Type) (reflect.Value, error) {
+	res := reflect.MakeSlice(sliceType, len(items), len(items))
+	elemType := sliceType.Elem()
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		target := res.Index(i)
+		if target.Kind() == reflect.Ptr {
+			target.Set(reflect.New(elemType.Elem()))
+			target = target.Elem()
+		}
+
+		if nested, ok := item.([]interface{}); ok {
+			if target.Kind() != reflect.Slice {
+				return res, fmt.Errorf("unable to cast nested array into %s", target.Type().String())
+			}
+			v, err := castArrayItems(nested, target.Type())
+			if err != nil {
+				return res, err
+			}
+			target.Set(v)
+			continue
+		}
+
+		if err := setArrayElement(target, item.(string)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func setArrayElement(target reflect.Value, str string) error {
+	if target.Type() == reflect.TypeOf(time.Time{}) {
+		dt, err := parseTime(str)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(dt))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(str)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(str, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(str, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+
+	case reflect.Bool:
+		target.SetBool(str == "t" || str == "true")
+
+	case reflect.Interface:
+		target.Set(reflect.ValueOf(str))
+
+	default:
+		ptr := reflect.New(target.Type())
+		if err := json.Unmarshal([]byte(str), ptr.Interface()); err != nil {
+			return fmt.Errorf("unable to cast array element into %s: %s", target.Type().String(), err.Error())
+		}
+		target.Set(ptr.Elem())
+	}
+	return nil
+}
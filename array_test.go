@@ -0,0 +1,58 @@
+package flexpg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArray(t *testing.T) {
+	cv.Convey("writing array", t, func() {
+		q := new(Query)
+		cv.So(q.ValueToSQlValue(Array([]string{"a", `b "c"`, "it's"})), cv.ShouldEqual, `'{"a","b \"c\"","it''s"}'`)
+		cv.So(q.ValueToSQlValue(Array([]int64{1, 2})), cv.ShouldEqual, "'{1,2}'")
+		cv.So(q.ValueToSQlValue(Array([][]int{{1, 2}, {3, 4}})), cv.ShouldEqual, "'{{1,2},{3,4}}'")
+		cv.So(q.ValueToSQlValue(Array([]*string{nil})), cv.ShouldEqual, "'{NULL}'")
+		cv.So(q.ValueToSQlValue(Array([]string(nil))), cv.ShouldEqual, "NULL")
+		cv.So(q.ValueToSQlValue(Array([]float32{1.1})), cv.ShouldEqual, "'{1.1}'")
+
+		dt := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.FixedZone("", 7*3600))
+		cv.So(q.ValueToSQlValue(Array([]time.Time{time.Now(), dt})), cv.ShouldNotContainSubstring, "m=")
+		cv.So(q.ValueToSQlValue(Array([]time.Time{dt})), cv.ShouldEqual, `'{"2021-03-04 05:06:07.123457+07:00:00"}'`)
+
+		cv.Convey("reading array", func() {
+			strs, err := castArray(`{a,"b \"c\"",NULL,"d,e"}`, reflect.TypeOf([]string{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(strs, cv.ShouldResemble, []string{"a", `b "c"`, "", "d,e"})
+
+			ints, err := castArray(`{{1,2},{3,4}}`, reflect.TypeOf([][]int{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(ints, cv.ShouldResemble, [][]int{{1, 2}, {3, 4}})
+
+			ptrs, err := castArray(`{1.5,NULL}`, reflect.TypeOf(&[]*float64{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(*(*ptrs.(*[]*float64))[0], cv.ShouldEqual, 1.5)
+			cv.So((*ptrs.(*[]*float64))[1], cv.ShouldBeNil)
+
+			dts, err := castArray(`{"2021-03-04 05:06:07.123457+07",NULL}`, reflect.TypeOf([]time.Time{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(dts.([]time.Time)[0].Equal(dt.Round(time.Microsecond)), cv.ShouldBeTrue)
+			cv.So(dts.([]time.Time)[1].IsZero(), cv.ShouldBeTrue)
+
+			_, err = castArray(`{1,2`, reflect.TypeOf([]int{}))
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+
+		cv.Convey("array filters", func() {
+			where, err := q.BuildFilter(dbflex.Or(
+				ArrayAny("tags", "go"),
+				ArrayContains("tags", []string{"go", "pg"}),
+				ArrayOverlap("ids", []int{1, 2})))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, `('go' = ANY(tags) OR tags @> '{"go","pg"}' OR ids && '{1,2}')`)
+		})
+	})
+}
@@ -0,0 +1,271 @@
+package flexpg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// columnType is a normalized PostgreSQL column type, used to compare the type declared on a struct
+// against the type reported by information_schema
+type columnType struct {
+	Name      string
+	Length    int
+	Precision int
+	Scale     int
+}
+
+var columnTypeAliases = map[string]string{
+	"character varying":           "varchar",
+	"character":                   "char",
+	"bpchar":                      "char",
+	"int":                         "integer",
+	"int4":                        "integer",
+	"serial":                      "integer",
+	"serial4":                     "integer",
+	"int2":                        "smallint",
+	"smallserial":                 "smallint",
+	"serial2":                     "smallint",
+	"int8":                        "bigint",
+	"bigserial":                   "bigint",
+	"serial8":                     "bigint",
+	"decimal":                     "numeric",
+	"float4":                      "real",
+	"float8":                      "double precision",
+	"float":                       "double precision",
+	"bool":                        "boolean",
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+	"time without time zone":      "time",
+	"time with time zone":         "timetz",
+}
+
+// parseColumnType parses type declaration such as "varchar(32)", "numeric (64,8)" or "int4"
+func parseColumnType(s string) columnType {
+	ct := columnType{}
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	isArray := false
+	if strings.HasSuffix(s, "[]") {
+		isArray = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "[]"))
+	} else if strings.HasPrefix(s, "_") {
+		// udt_name of array columns is prefixed by underscore, ie: _text, _int4
+		isArray = true
+		s = s[1:]
+	}
+
+	name := s
+	if idx := strings.Index(s, "("); idx >= 0 {
+		name = strings.TrimSpace(s[:idx])
+		mods := strings.Split(strings.Trim(strings.TrimSpace(s[idx:]), "()"), ",")
+		first, _ := strconv.Atoi(strings.TrimSpace(mods[0]))
+		second := 0
+		if len(mods) > 1 {
+			second, _ = strconv.Atoi(strings.TrimSpace(mods[1]))
+		}
+		switch name {
+		case "numeric", "decimal":
+			ct.Precision = first
+			ct.Scale = second
+		default:
+			ct.Length = first
+		}
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	if alias, ok := columnTypeAliases[name]; ok {
+		name = alias
+	}
+	ct.Name = name
+	if isArray {
+		ct.Name += "[]"
+	}
+	return ct
+}
+
+// columnTypeFromMeta builds columnType from a record of information_schema.columns
+func columnTypeFromMeta(udtName string, length, precision, scale int) columnType {
+	ct := parseColumnType(udtName)
+	switch ct.Name {
+	case "varchar", "char":
+		ct.Length = length
+	case "numeric":
+		ct.Precision = precision
+		ct.Scale = scale
+	}
+	return ct
+}
+
+// String returns SQL representation of the type
+func (ct columnType) String() string {
+	base := ct.Name
+	suffix := ""
+	if strings.HasSuffix(base, "[]") {
+		base = strings.TrimSuffix(base, "[]")
+		suffix = "[]"
+	}
+	switch {
+	case ct.Precision > 0:
+		return fmt.Sprintf("%s(%d,%d)%s", base, ct.Precision, ct.Scale, suffix)
+	case ct.Length > 0:
+		return fmt.Sprintf("%s(%d)%s", base, ct.Length, suffix)
+	}
+	return base + suffix
+}
+
+// Equal returns true if both types are the same, including its length, precision and scale
+func (ct columnType) Equal(other columnType) bool {
+	return ct.Name == other.Name &&
+		ct.Length == other.Length &&
+		ct.Precision == other.Precision &&
+		ct.Scale == other.Scale
+}
+
+var (
+	textTypeRank = map[string]int{
+		"char":    1,
+		"varchar": 2,
+		"text":    3,
+	}
+
+	numberTypeRank = map[string]int{
+		"smallint":         1,
+		"integer":          2,
+		"bigint":           3,
+		"real":             4,
+		"numeric":          5,
+		"double precision": 5,
+	}
+
+	integerTypeDigits = map[string]int{
+		"smallint": 5,
+		"integer":  10,
+		"bigint":   19,
+	}
+
+	// integerTypeBits and floatTypeMantissa tell whether every integer value is exactly representable as float
+	integerTypeBits = map[string]int{
+		"smallint": 15,
+		"integer":  31,
+		"bigint":   63,
+	}
+
+	floatTypeMantissa = map[string]int{
+		"real":             24,
+		"double precision": 53,
+	}
+
+	timeTypeRank = map[string]int{
+		"date":        1,
+		"timestamp":   2,
+		"timestamptz": 2,
+	}
+)
+
+// typeFamily returns family of a type, converting between types of different families might lose data or fail
+func typeFamily(name string) string {
+	if _, ok := textTypeRank[name]; ok {
+		return "text"
+	}
+	if _, ok := numberTypeRank[name]; ok {
+		return "number"
+	}
+	if _, ok := timeTypeRank[name]; ok {
+		return "time"
+	}
+	return name
+}
+
+// isSafeWidening returns true if any value of ct could be converted into target between different type families,
+// only unbounded text holds text representation of every type
+func (ct columnType) isSafeWidening(target columnType) bool {
+	return target.Name == "text" || (target.Name == "varchar" && target.Length == 0)
+}
+
+// IsNarrowing returns true if converting a column from ct to target might lose data. Conversion between type
+// families, ie: integer to varchar(3) or varchar to integer, is narrowing unless it is a safe widening
+func (ct columnType) IsNarrowing(target columnType) bool {
+	fromArray, toArray := strings.HasSuffix(ct.Name, "[]"), strings.HasSuffix(target.Name, "[]")
+	if fromArray != toArray {
+		return true
+	}
+	ct.Name, target.Name = strings.TrimSuffix(ct.Name, "[]"), strings.TrimSuffix(target.Name, "[]")
+
+	if typeFamily(ct.Name) != typeFamily(target.Name) {
+		return !ct.isSafeWidening(target)
+	}
+
+	if _, ok := textTypeRank[ct.Name]; ok {
+		if target.Length == 0 {
+			// char without length is char(1)
+			return target.Name == "char" && ct.Length != 1
+		}
+		return ct.Length == 0 || target.Length < ct.Length
+	}
+
+	if fromRank, ok := numberTypeRank[ct.Name]; ok {
+		toRank := numberTypeRank[target.Name]
+		if ct.Name == "numeric" && target.Name == "numeric" {
+			if target.Precision == 0 {
+				return false
+			}
+			if ct.Precision == 0 {
+				return true
+			}
+			return target.Precision-target.Scale < ct.Precision-ct.Scale || target.Scale < ct.Scale
+		}
+		if target.Name == "numeric" && target.Precision > 0 {
+			digits, isInteger := integerTypeDigits[ct.Name]
+			return !isInteger || target.Precision-target.Scale < digits
+		}
+		if mantissa, toFloat := floatTypeMantissa[target.Name]; toFloat {
+			// numeric is exact, converting it into float rounds its value
+			if bits, isInteger := integerTypeBits[ct.Name]; isInteger {
+				return bits > mantissa
+			}
+			fromMantissa, isFloat := floatTypeMantissa[ct.Name]
+			return !isFloat || fromMantissa > mantissa
+		}
+		return toRank < fromRank
+	}
+
+	if fromRank, ok := timeTypeRank[ct.Name]; ok {
+		return timeTypeRank[target.Name] < fromRank
+	}
+
+	return false
+}
+
+// alterColumnTypeCommand returns alter clause to change type of a column, casting existing value into new type
+func alterColumnTypeCommand(fieldName string, target columnType, collation string) string {
+	if collation != "" {
+		return fmt.Sprintf("alter %s type %s collate \"%s\" using %s::%s", fieldName, target.String(), collation, fieldName, target.String())
+	}
+	return fmt.Sprintf("alter %s type %s using %s::%s", fieldName, target.String(), fieldName, target.String())
+}
+
+// typeDefaultValue returns zero value of the type, used to fill existing rows when a column becomes NOT NULL
+func typeDefaultValue(ct columnType) string {
+	if strings.HasSuffix(ct.Name, "[]") {
+		return "'{}'"
+	}
+	switch ct.Name {
+	case "smallint", "integer", "bigint", "real", "numeric", "double precision":
+		return "0"
+	case "boolean":
+		return "false"
+	case "timestamptz", "timestamp", "date":
+		return "to_timestamp(0)"
+	case "time", "timetz":
+		return "'00:00:00'"
+	case "interval":
+		return "'0'"
+	case "jsonb", "json":
+		return "'{}'"
+	case "uuid":
+		return "'00000000-0000-0000-0000-000000000000'"
+	case "bytea":
+		return "''::bytea"
+	}
+	return "''"
+}
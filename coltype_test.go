@@ -0,0 +1,66 @@
+package flexpg
+
+import (
+	"testing"
+
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColumnType(t *testing.T) {
+	cv.Convey("parsing column type", t, func() {
+		cv.So(parseColumnType("varchar(32)"), cv.ShouldResemble, columnType{Name: "varchar", Length: 32})
+		cv.So(parseColumnType("numeric (64,8)"), cv.ShouldResemble, columnType{Name: "numeric", Precision: 64, Scale: 8})
+		cv.So(parseColumnType("int4").Name, cv.ShouldEqual, "integer")
+		cv.So(parseColumnType("_text").Name, cv.ShouldEqual, "text[]")
+		cv.So(parseColumnType("Character Varying(10)").String(), cv.ShouldEqual, "varchar(10)")
+
+		cv.Convey("comparing with database meta", func() {
+			cv.So(columnTypeFromMeta("varchar", 32, 0, 0).Equal(parseColumnType("varchar(32)")), cv.ShouldBeTrue)
+			cv.So(columnTypeFromMeta("numeric", 0, 64, 8).Equal(parseColumnType("numeric (64,8)")), cv.ShouldBeTrue)
+			cv.So(columnTypeFromMeta("int4", 0, 32, 0).Equal(parseColumnType("integer")), cv.ShouldBeTrue)
+			cv.So(columnTypeFromMeta("varchar", 32, 0, 0).Equal(parseColumnType("varchar")), cv.ShouldBeFalse)
+		})
+
+		cv.Convey("detecting narrowing", func() {
+			cv.So(parseColumnType("varchar(50)").IsNarrowing(parseColumnType("varchar(20)")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("varchar(20)").IsNarrowing(parseColumnType("varchar(50)")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("text").IsNarrowing(parseColumnType("varchar(20)")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("bigint").IsNarrowing(parseColumnType("integer")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("integer").IsNarrowing(parseColumnType("bigint")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("numeric(64,8)").IsNarrowing(parseColumnType("numeric(32,8)")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("bigint").IsNarrowing(parseColumnType("numeric(64,8)")), cv.ShouldBeFalse)
+		})
+
+		cv.Convey("detecting narrowing into float", func() {
+			cv.So(parseColumnType("numeric(64,8)").IsNarrowing(parseColumnType("double precision")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("numeric").IsNarrowing(parseColumnType("real")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("bigint").IsNarrowing(parseColumnType("double precision")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("bigint").IsNarrowing(parseColumnType("real")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("integer").IsNarrowing(parseColumnType("real")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("double precision").IsNarrowing(parseColumnType("real")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("integer").IsNarrowing(parseColumnType("double precision")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("smallint").IsNarrowing(parseColumnType("real")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("real").IsNarrowing(parseColumnType("double precision")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("double precision").IsNarrowing(parseColumnType("numeric")), cv.ShouldBeFalse)
+		})
+
+		cv.Convey("detecting narrowing between type families", func() {
+			cv.So(parseColumnType("integer").IsNarrowing(parseColumnType("varchar(3)")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("varchar").IsNarrowing(parseColumnType("integer")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("numeric").IsNarrowing(parseColumnType("integer")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("numeric(10,2)").IsNarrowing(parseColumnType("integer")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("timestamptz").IsNarrowing(parseColumnType("bigint")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("text").IsNarrowing(parseColumnType("jsonb")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("text[]").IsNarrowing(parseColumnType("text")), cv.ShouldBeTrue)
+			cv.So(parseColumnType("integer").IsNarrowing(parseColumnType("text")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("timestamptz").IsNarrowing(parseColumnType("varchar")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("integer[]").IsNarrowing(parseColumnType("text[]")), cv.ShouldBeFalse)
+			cv.So(parseColumnType("varchar(10)[]").IsNarrowing(parseColumnType("varchar(5)[]")), cv.ShouldBeTrue)
+		})
+
+		cv.Convey("generating alter command", func() {
+			cv.So(alterColumnTypeCommand("amount", parseColumnType("integer"), ""), cv.ShouldEqual,
+				"alter amount type integer using amount::integer")
+		})
+	})
+}
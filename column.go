@@ -0,0 +1,104 @@
+package flexpg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/sebarcode/codekit"
+)
+
+// Column is metadata of a result column of a cursor
+type Column struct {
+	Name string
+	// DBType is database type name in lower case, ie: int4, varchar, jsonb. Array type is prefixed by underscore, ie: _text
+	DBType      string
+	Nullable    bool
+	HasNullable bool
+	Length      int64
+	HasLength   bool
+	Precision   int64
+	Scale       int64
+	HasDecimal  bool
+}
+
+// newColumns reads metadata of result columns of rows
+func newColumns(rows *sql.Rows) ([]Column, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Column, len(types))
+	for i, ct := range types {
+		col := Column{Name: ct.Name(), DBType: strings.ToLower(ct.DatabaseTypeName())}
+		col.Nullable, col.HasNullable = ct.Nullable()
+		col.Length, col.HasLength = ct.Length()
+		col.Precision, col.Scale, col.HasDecimal = ct.DecimalSize()
+		res[i] = col
+	}
+	return res, nil
+}
+
+var columnArrayTypes = map[string]reflect.Type{
+	"_int2":    reflect.TypeOf([]int64{}),
+	"_int4":    reflect.TypeOf([]int64{}),
+	"_int8":    reflect.TypeOf([]int64{}),
+	"_float4":  reflect.TypeOf([]float64{}),
+	"_float8":  reflect.TypeOf([]float64{}),
+	"_bool":    reflect.TypeOf([]bool{}),
+	"_text":    reflect.TypeOf([]string{}),
+	"_varchar": reflect.TypeOf([]string{}),
+	"_bpchar":  reflect.TypeOf([]string{}),
+	"_uuid":    reflect.TypeOf([]string{}),
+	"_numeric": reflect.TypeOf([]string{}),
+}
+
+// castByColumnType converts value returned by database driver for a map destination based on type of its column.
+// json and jsonb are decoded, arrays are returned as slice, numeric is kept as text to be exact and bytea as []byte.
+// It returns false if the column type needs no special handling
+func castByColumnType(value interface{}, dbType string) (interface{}, bool, error) {
+	bs, isBytes := value.([]byte)
+	if !isBytes {
+		return nil, false, nil
+	}
+
+	switch {
+	case dbType == "json" || dbType == "jsonb":
+		var v interface{}
+		if err := json.Unmarshal(bs, &v); err != nil {
+			return nil, true, err
+		}
+		return jsonToMap(v), true, nil
+
+	case dbType == "bytea":
+		return bs, true, nil
+
+	case strings.HasPrefix(dbType, "_"):
+		if sliceType, ok := columnArrayTypes[dbType]; ok {
+			v, err := castArray(string(bs), sliceType)
+			return v, true, err
+		}
+		items, err := parseArrayLiteral(string(bs))
+		return items, true, err
+	}
+	return nil, false, nil
+}
+
+// jsonToMap converts objects of decoded json into codekit.M
+func jsonToMap(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(codekit.M, len(v))
+		for key, item := range v {
+			m[key] = jsonToMap(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonToMap(item)
+		}
+		return v
+	}
+	return v
+}
@@ -4,8 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"reflect"
+	"net/url"
 	"strings"
+	"time"
 
 	"git.kanosolution.net/kano/dbflex"
 
@@ -20,7 +21,11 @@ type Connection struct {
 	db *sql.DB
 	tx *sql.Tx
 
-	txIsDisabled bool
+	txIsDisabled        bool
+	lossyAlterIsAllowed bool
+	setNotNullIsAllowed bool
+	timeZone            string
+	location            *time.Location
 }
 
 func init() {
@@ -34,24 +39,69 @@ func init() {
 
 // Connect to database instance
 func (c *Connection) Connect() error {
+	location, err := c.sessionLocation()
+	if err != nil {
+		return err
+	}
+	c.location = location
+
+	db, err := sql.Open("postgres", c.connString())
+	c.db = db
+	return err
+}
+
+// connString returns DSN of the connection. Config is passed as connection parameters as is, so its values should be
+// escaped by the caller the same way as before. Only time zone set by SetTimeZone is escaped
+func (c *Connection) connString() string {
 	sqlconnstring := fmt.Sprintf("%s/%s", c.Host, c.Database)
 	if c.User != "" {
 		sqlconnstring = fmt.Sprintf("%s:%s@%s", c.User, c.Password, sqlconnstring)
 	}
 	sqlconnstring = "postgres://" + sqlconnstring
+	hasTimeZone := false
 	configs := strings.Join(func() []string {
 		var out []string
 		for k, v := range c.Config {
-			out = append(out, fmt.Sprintf("%s=%s", k, v))
+			if strings.EqualFold(k, "timezone") {
+				hasTimeZone = true
+			}
+			out = append(out, fmt.Sprintf("%s=%v", k, v))
 		}
 		return out
 	}(), "&")
+	if !hasTimeZone && c.timeZone != "" {
+		if configs != "" {
+			configs += "&"
+		}
+		configs += "TimeZone=" + url.QueryEscape(c.timeZone)
+	}
 	if configs != "" {
 		sqlconnstring = sqlconnstring + "?" + configs
 	}
-	db, err := sql.Open("postgres", sqlconnstring)
-	c.db = db
-	return err
+	return sqlconnstring
+}
+
+// SetTimeZone sets TimeZone of database session, ie: UTC or Asia/Jakarta. It should be called before Connect,
+// TimeZone on connection config takes precedence. Time values read from database are converted into this zone
+func (c *Connection) SetTimeZone(name string) {
+	c.timeZone = name
+}
+
+func (c *Connection) sessionLocation() (*time.Location, error) {
+	name := c.timeZone
+	for k, v := range c.Config {
+		if strings.EqualFold(k, "timezone") {
+			name = fmt.Sprintf("%v", v)
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %s. %s", name, err.Error())
+	}
+	return location, nil
 }
 
 func (c *Connection) State() string {
@@ -87,23 +137,74 @@ func (c *Connection) DropTable(name string) error {
 	return e
 }
 
+// EnsureTable creates table of obj, or updates columns and constraints of the existing table to follow obj.
+// Columns are derived from exported fields of obj and these tags:
+//
+//	db_type:"varchar(32)"            column type, derived from field type when omitted
+//	db_default:"now()"               default value, written as is as SQL expression
+//	db_null:"true"                   nullable column, db_null:"false" or required tag makes it NOT NULL.
+//	                                 When omitted, pointer, interface, map, slice and sql.Null* fields are nullable,
+//	                                 other fields are NOT NULL. Existing nullable column is only made NOT NULL
+//	                                 after AllowSetNotNull(true)
+//	db_unique:"true"                 unique constraint, named <table>_<column>_key. Any other value is a group name,
+//	                                 columns of the same group are put into one constraint named <table>_<group>_key
+//	db_check:"amount >= 0"           check constraint, named <table>_<column>_check
+//	db_comment:"customer e-mail"     column comment
+//	db_collate:"C"                   column collation
+//	db_identity:"always"             identity column, value is either always or default
+//	db_generated:"price * qty"       stored generated column, it is excluded from insert and update
+//	db_json:"true"                   stores embedded struct as jsonb column, by default its fields are flattened
+//	db_array:"true"                  stores slice as native array column, ie: text[] or bigint[], instead of jsonb
+//	db_precision:"18" db_scale:"2"   numeric column with given precision and scale, ie: numeric(18,2). Use Decimal
+//	                                 or math/big types to keep the value exact
+//	db_tsvector:"title,body"         full text search column generated from given columns, db_tsconfig:"english"
+//	                                 sets its text search configuration
+//	db_version:"true"                version of the row for optimistic concurrency, update only matches the row with
+//	                                 the same version and increments it, otherwise ErrVersionConflict is returned.
+//	                                 The struct should be written as pointer
+//	db_ref:"customers(id)"           foreign key to referenced table and column, column is id when omitted
+//	db_ondelete:"cascade"            on delete action of foreign key: cascade, restrict, set null, set default or no action
+//	db_onupdate:"cascade"            on update action of foreign key
+//	db_deferrable:"true"             deferrable foreign key, use db_deferrable:"deferred" to make it initially deferred
+//
+// Field which type implements Enum is stored as enum type, it is created or updated by EnsureTable. New values of
+// existing enum type are committed in their own transaction before the table is changed, even inside BeginTx.
+// float32 and float64 fields are created as real and double precision, existing numeric column of such field created by
+// earlier versions is kept as is. Use db_type or db_precision tag to change it explicitly. int fields are bigint,
+// existing integer column of such field is widened into bigint.
+//
+// Foreign keys spanning several columns are declared by implementing ForeignKeyProvider. Unique and foreign key
+// constraints created by EnsureTable are marked by a comment, only these are dropped when the model no longer declares
+// them. Primary key referenced by foreign keys of other tables is not changed, an error is returned instead
 func (c *Connection) EnsureTable(name string, keys []string, obj interface{}) error {
 	var e error
 	cmdTxts := []string{}
+
+	cols, e := tableColumns(obj)
+	if e != nil {
+		return e
+	}
+	enumCmdTxts, enumValueCmdTxts, e := createCommandForEnums(c, cols)
+	if e != nil {
+		return e
+	}
+	if e = c.addEnumValues(enumValueCmdTxts); e != nil {
+		return e
+	}
+
 	if !c.HasTable(name) {
-		if cmdTxt, e := createCommandForCreateTable(name, keys, obj); e != nil {
+		if cmdTxts, e = createCommandForCreateTable(name, keys, obj); e != nil {
 			return e
-		} else {
-			cmdTxts = append(cmdTxts, cmdTxt)
 		}
 	} else {
-		if cmdTxts, e = createCommandForUpdatingTable(c, name, obj); e != nil {
+		if cmdTxts, e = createCommandForUpdatingTable(c, name, keys, obj); e != nil {
 			return e
 		}
-		if len(cmdTxts) == 0 {
+		if len(cmdTxts) == 0 && len(enumCmdTxts) == 0 {
 			return nil
 		}
 	}
+	cmdTxts = append(enumCmdTxts, cmdTxts...)
 
 	logger := dbflex.Logger()
 	for _, cmdTxt := range cmdTxts {
@@ -121,166 +222,310 @@ func (c *Connection) EnsureTable(name string, keys []string, obj interface{}) er
 	return nil
 }
 
-func createCommandForCreateTable(name string, keys []string, obj interface{}) (string, error) {
+func createCommandForCreateTable(name string, keys []string, obj interface{}) ([]string, error) {
 	tableCreateCommand := "CREATE TABLE %s (%s);"
+	cols, e := tableColumns(obj)
+	if e != nil {
+		return nil, e
+	}
+
 	fields := []string{}
-	v := reflect.ValueOf(obj)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	if v.Kind() == reflect.Struct {
-		t := v.Type()
-		fnum := t.NumField()
-		for i := 0; i < fnum; i++ {
-			f := t.Field(i)
-			tag := f.Tag
-			fieldName := f.Name
-			alias := f.Tag.Get(codekit.TagName())
-			originalFieldName := fieldName
-			if alias == "-" {
-				continue
-			}
-			if alias != "" {
-				fieldName = alias
-			}
+	constraints := []string{}
+	comments := []string{}
+	if pk := tablePrimaryKey(name, keys, obj, cols); len(pk.Fields) > 0 {
+		constraints = append(constraints, fmt.Sprintf("CONSTRAINT %s %s", pk.Name, pk.PrimaryKeyDefinition()))
+	}
+	for _, uk := range tableUniqueKeys(name, cols) {
+		constraints = append(constraints, fmt.Sprintf("CONSTRAINT %s %s", uk.Name, uk.UniqueDefinition()))
+		comments = append(comments, managedConstraintCommand(name, uk.Name))
+	}
+	for _, col := range cols {
+		fields = append(fields, col.Definition())
+		constraints = append(constraints, col.Constraints(name)...)
+		if col.Comment != "" {
+			comments = append(comments, col.CommentCommand(name))
+		}
+	}
+	for _, fk := range tableForeignKeys(obj, cols) {
+		constraints = append(constraints, fmt.Sprintf("CONSTRAINT %s %s", fk.ConstraintName(name), fk.Definition()))
+		comments = append(comments, managedConstraintCommand(name, fk.ConstraintName(name)))
+	}
 
-			fieldType := f.Tag.Get("db_type")
-			if fieldType == "" {
-				fieldType = f.Type.String()
-				if fieldType == "string" {
-					fieldType = "varchar"
-				} else if fieldType != "interface{}" && strings.HasPrefix(fieldType, "int") {
-					fieldType = "integer"
-				} else if strings.Contains(fieldType, "time.Time") {
-					fieldType = "timestamptz"
-				} else if fieldType == "float32" {
-					fieldType = "numeric (32,8)"
-				} else if fieldType == "float64" {
-					fieldType = "numeric (64,8)"
-				} else if fieldType == "bool" {
-					fieldType = "boolean"
-				} else {
-					fieldType = "jsonb"
-				}
-			}
+	res := []string{fmt.Sprintf(tableCreateCommand, name, strings.Join(append(fields, constraints...), ", "))}
+	return append(res, comments...), nil
+}
 
-			options := []string{}
-			if codekit.HasMember(keys, originalFieldName) || codekit.HasMember(keys, fieldName) {
-				options = append(options, "PRIMARY KEY")
-			}
-			if _, ok := tag.Lookup("required"); ok {
-				options = append(options, "NOT NULL")
-			}
-			fields = append(fields, strings.Replace(fmt.Sprintf("%s %s %s",
-				strings.ToLower(fieldName),
-				fieldType,
-				strings.Join(options, " ")),
-				"  ", " ", -1))
+func isKeyColumn(keys []string, col columnDef) bool {
+	for _, key := range keys {
+		if strings.EqualFold(key, col.FieldName) || strings.EqualFold(key, col.Name) {
+			return true
 		}
-	} else {
-		return "", errors.New("object should be a struct")
 	}
-	return fmt.Sprintf(tableCreateCommand, name, strings.Join(fields, ", ")), nil
+	return false
 }
 
-func createCommandForUpdatingTable(c dbflex.IConnection, name string, obj interface{}) ([]string, error) {
-	res := []string{}
+// tableMeta is columns and constraints of an existing table
+type tableMeta struct {
+	Columns     []codekit.M
+	Constraints []codekit.M
+	// PrimaryKeyRefs are foreign keys of any table referencing primary key of the table
+	PrimaryKeyRefs []codekit.M
+}
 
-	// get fields
-	name = strings.ToLower(name)
-	tableFields := []codekit.M{}
-	sql := "select column_name,udt_name,is_nullable as isnull, 0::bool as included from information_schema.columns where table_name='" + name + "' order by ordinal_position"
-	e := c.Cursor(dbflex.SQL(sql), nil).Fetchs(&tableFields, 0).Close()
+// tableMeta reads columns of a table from information_schema and its constraints from pg_constraint
+func (c *Connection) tableMeta(name string) (tableMeta, error) {
+	meta := tableMeta{Columns: []codekit.M{}, Constraints: []codekit.M{}, PrimaryKeyRefs: []codekit.M{}}
+	sql := "select column_name,udt_name,is_nullable as isnull," +
+		" coalesce(character_maximum_length,0)::int as charlen," +
+		" coalesce(numeric_precision,0)::int as numprecision," +
+		" coalesce(numeric_scale,0)::int as numscale," +
+		" coalesce(column_default,'') as column_default, coalesce(collation_name,'') as collation_name," +
+		" is_identity, coalesce(identity_generation,'') as identity_generation, is_generated," +
+		" coalesce(col_description(to_regclass('" + name + "'), ordinal_position),'') as comment," +
+		" 0::bool as included from information_schema.columns where table_name='" + name + "' order by ordinal_position"
+	if e := c.Cursor(dbflex.SQL(sql), nil).Fetchs(&meta.Columns, 0).Close(); e != nil {
+		return meta, errors.New("unable to get table meta. " + e.Error())
+	}
+
+	sql = "select conname, contype::text as contype, pg_get_constraintdef(oid) as def," +
+		" coalesce(obj_description(oid, 'pg_constraint'),'') as comment" +
+		" from pg_constraint where conrelid=to_regclass('" + name + "')"
+	if e := c.Cursor(dbflex.SQL(sql), nil).Fetchs(&meta.Constraints, 0).Close(); e != nil {
+		return meta, errors.New("unable to get table constraints. " + e.Error())
+	}
+
+	sql = "select fk.conname, fk.conrelid::regclass::text as tablename from pg_constraint fk" +
+		" inner join pg_constraint pk on pk.conindid=fk.conindid and pk.conrelid=fk.confrelid and pk.contype='p'" +
+		" where fk.contype='f' and fk.confrelid=to_regclass('" + name + "')"
+	if e := c.Cursor(dbflex.SQL(sql), nil).Fetchs(&meta.PrimaryKeyRefs, 0).Close(); e != nil {
+		return meta, errors.New("unable to get foreign keys referencing the table. " + e.Error())
+	}
+	return meta, nil
+}
+
+func createCommandForUpdatingTable(c *Connection, name string, keys []string, obj interface{}) ([]string, error) {
+	meta, e := c.tableMeta(strings.ToLower(name))
 	if e != nil {
-		return res, errors.New("unable to get table meta. " + e.Error())
+		return []string{}, e
 	}
+	return updateTableCommands(name, keys, obj, meta, c.lossyAlterIsAllowed, c.setNotNullIsAllowed)
+}
+
+// updateTableCommands returns commands to update existing table described by meta to follow obj
+func updateTableCommands(name string, keys []string, obj interface{}, meta tableMeta,
+	lossyAlterIsAllowed, setNotNullIsAllowed bool) ([]string, error) {
+	res := []string{}
+	name = strings.ToLower(name)
 
 	// convert fields to map to ease comparison
-	mfs := make(map[string]codekit.M, len(tableFields))
-	for _, f := range tableFields {
+	mfs := make(map[string]codekit.M, len(meta.Columns))
+	for _, f := range meta.Columns {
 		mfs[strings.ToLower(f.GetString("column_name"))] = f
 	}
 
-	tableUpdateCommand := "ALTER TABLE %s %s;"
-	fields := []string{}
-	v := reflect.ValueOf(obj)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	mcs := make(map[string]string, len(meta.Constraints))
+	managedConstraints := map[string]bool{}
+	oldPrimaryKey := ""
+	oldUniqueKeys := []string{}
+	oldForeignKeys := []string{}
+	for _, tc := range meta.Constraints {
+		conName := strings.ToLower(tc.GetString("conname"))
+		mcs[conName] = tc.GetString("def")
+		managedConstraints[conName] = tc.GetString("comment") == managedConstraintComment
+		switch tc.GetString("contype") {
+		case "p":
+			oldPrimaryKey = conName
+		case "u":
+			oldUniqueKeys = append(oldUniqueKeys, conName)
+		case "f":
+			oldForeignKeys = append(oldForeignKeys, conName)
+		}
 	}
 
-	if v.Kind() != reflect.Struct {
-		return res, errors.New("object should be a struct")
+	cols, e := tableColumns(obj)
+	if e != nil {
+		return res, e
 	}
 
-	t := v.Type()
-	fnum := t.NumField()
-	hasChange := false
-	for i := 0; i < fnum; i++ {
-		f := t.Field(i)
-		fieldName := f.Name
-		alias := f.Tag.Get(codekit.TagName())
-		if alias == "-" {
+	tableUpdateCommand := "ALTER TABLE %s %s;"
+	prerequisites := []string{}
+	fields := []string{}
+	others := []string{}
+	nullableColumns := []string{}
+	for _, col := range cols {
+		// check if field already exist
+		old, exist := mfs[col.Name]
+		if !exist {
+			addCol := col
+			if addCol.NotNull && addCol.Default == "" && addCol.Identity == "" && addCol.Generated == "" {
+				// fill existing rows with default value of the type, then remove the default
+				addCol.Default = columnFillValue(col)
+				others = append(others, fmt.Sprintf("ALTER TABLE %s ALTER %s DROP DEFAULT;", name, col.Name))
+			}
+			fields = append(fields, "add "+addCol.Definition())
+			for _, constraint := range col.Constraints(name) {
+				fields = append(fields, "add "+constraint)
+			}
+			if col.Comment != "" {
+				others = append(others, col.CommentCommand(name))
+			}
 			continue
 		}
-		if alias != "" {
-			fieldName = alias
+		old.Set("included", true)
+
+		// expression of generated column can not be altered
+		if col.Generated != "" || old.GetString("is_generated") == "ALWAYS" {
+			continue
 		}
-		dbType := f.Tag.Get("db_type")
-		fieldType := f.Type.String()
 
-		// check if field already exist
-		old, exist := mfs[strings.ToLower(fieldName)]
-
-		if dbType == "" {
-			if fieldType == "string" {
-				fieldType = "varchar"
-			} else if fieldType != "interface{}" && strings.HasPrefix(fieldType, "int") {
-				fieldType = "integer"
-			} else if strings.Contains(fieldType, "time.Time") {
-				fieldType = "timestamptz"
-			} else if fieldType == "float32" {
-				fieldType = "numeric (32,8)"
-			} else if fieldType == "float64" {
-				fieldType = "numeric (64,8)"
-			} else if fieldType == "bool" {
-				fieldType = "boolean"
-			} else {
-				fieldType = "jsonb"
+		oldType := columnTypeFromMeta(old.GetString("udt_name"),
+			old.GetInt("charlen"), old.GetInt("numprecision"), old.GetInt("numscale"))
+		newType := parseColumnType(col.Type)
+		if col.FloatNumeric && oldType.Name == "numeric" {
+			newType = oldType
+		}
+		collationChanged := col.Collate != "" && !strings.EqualFold(col.Collate, old.GetString("collation_name"))
+		if !oldType.Equal(newType) || collationChanged {
+			if oldType.IsNarrowing(newType) && !lossyAlterIsAllowed {
+				return res, fmt.Errorf("changing type of %s.%s from %s to %s might lose data, call AllowLossyAlter(true) to proceed",
+					name, col.Name, oldType.String(), newType.String())
 			}
-		} else {
-			fieldType = dbType
+			fields = append(fields, alterColumnTypeCommand(col.Name, newType, col.Collate))
 		}
 
-		if exist {
-			oldUdtName := strings.ToLower(old.GetString("udt_name"))
-			if fieldType != oldUdtName {
-				hasChange = true
-				fields = append(fields, fmt.Sprintf("alter %s type %s", strings.ToLower(fieldName), fieldType))
+		oldIdentity := strings.ToLower(old.GetString("identity_generation"))
+		if old.GetString("is_identity") != "YES" {
+			oldIdentity = ""
+		}
+		switch {
+		case col.Identity != "" && oldIdentity == "":
+			fields = append(fields, fmt.Sprintf("alter %s add generated %s as identity", col.Name, col.Identity))
+		case col.Identity != "" && col.Identity != oldIdentity:
+			fields = append(fields, fmt.Sprintf("alter %s set generated %s", col.Name, col.Identity))
+		case col.Identity == "" && oldIdentity != "":
+			fields = append(fields, fmt.Sprintf("alter %s drop identity if exists", col.Name))
+		}
+
+		oldDefault := old.GetString("column_default")
+		if col.Identity == "" {
+			if col.Default != "" && !sameSQLExpr(col.Default, oldDefault) {
+				fields = append(fields, fmt.Sprintf("alter %s set default %s", col.Name, col.Default))
+			} else if col.Default == "" && oldDefault != "" && !strings.HasPrefix(oldDefault, "nextval(") {
+				fields = append(fields, fmt.Sprintf("alter %s drop default", col.Name))
 			}
-			old.Set("included", true)
-		} else {
-			hasChange = true
-			notnull := "NOT NULL DEFAULT "
-			switch fieldType {
-			case "text":
-				notnull += "''"
-			case "integer", "numeric (32,8)", "numeric (64,8)":
-				notnull += "0"
-			case "boolean":
-				notnull += " 'F'"
-			case "timestamptz":
-				notnull += "to_timestamp(0)"
-			default:
-				notnull += "''"
+		}
+
+		oldNotNull := old.GetString("isnull") == "NO"
+		if col.NotNull && !oldNotNull && !setNotNullIsAllowed {
+			nullableColumns = append(nullableColumns, col.Name)
+		} else if col.NotNull && !oldNotNull {
+			fillValue := col.Default
+			if fillValue == "" {
+				fillValue = columnFillValue(col)
+			}
+			prerequisites = append(prerequisites, fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NULL;", name, col.Name, fillValue, col.Name))
+			fields = append(fields, fmt.Sprintf("alter %s set not null", col.Name))
+		} else if !col.NotNull && oldNotNull && col.Identity == "" && !isKeyColumn(keys, col) {
+			fields = append(fields, fmt.Sprintf("alter %s drop not null", col.Name))
+		}
+
+		checkName := col.CheckName(name)
+		oldCheck, hasCheck := mcs[checkName]
+		oldCheck = strings.TrimPrefix(oldCheck, "CHECK ")
+		if hasCheck && (col.Check == "" || !sameSQLExpr(col.Check, oldCheck)) {
+			fields = append(fields, fmt.Sprintf("drop constraint %s", checkName))
+			hasCheck = false
+		}
+		if col.Check != "" && !hasCheck {
+			fields = append(fields, fmt.Sprintf("add constraint %s check (%s)", checkName, col.Check))
+		}
+
+		if col.Comment != old.GetString("comment") {
+			others = append(others, col.CommentCommand(name))
+		}
+	}
+
+	// existing NULL would be overwritten by fill value
+	if len(nullableColumns) > 0 {
+		return res, fmt.Errorf("columns %s of %s are nullable but declared as NOT NULL, existing NULL would be filled with "+
+			"default value. Call AllowSetNotNull(true) to proceed, or tag them with db_null:\"true\"",
+			strings.Join(nullableColumns, ", "), name)
+	}
+
+	// primary key is only changed when keys are given
+	if pk := tablePrimaryKey(name, keys, obj, cols); len(pk.Fields) > 0 {
+		if oldPrimaryKey != pk.Name || !sameKeyDefinition(pk.PrimaryKeyDefinition(), mcs[oldPrimaryKey]) {
+			if oldPrimaryKey != "" {
+				// dropping the key would fail halfway on the referencing foreign keys, or drop them on cascade
+				if len(meta.PrimaryKeyRefs) > 0 {
+					refs := make([]string, len(meta.PrimaryKeyRefs))
+					for i, ref := range meta.PrimaryKeyRefs {
+						refs[i] = ref.GetString("tablename") + "." + ref.GetString("conname")
+					}
+					return res, fmt.Errorf("unable to change primary key of %s, it is referenced by foreign keys %s. Drop them first",
+						name, strings.Join(refs, ", "))
+				}
+				prerequisites = append(prerequisites, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", name, oldPrimaryKey))
+			}
+			fields = append(fields, fmt.Sprintf("add constraint %s %s", pk.Name, pk.PrimaryKeyDefinition()))
+		}
+	}
+
+	// only unique constraints created by EnsureTable are dropped when they are no longer declared
+	declaredUniqueKeys := map[string]bool{}
+	for _, uk := range tableUniqueKeys(name, cols) {
+		declaredUniqueKeys[uk.Name] = true
+		oldDef, hasUnique := mcs[uk.Name]
+		if hasUnique && sameKeyDefinition(uk.UniqueDefinition(), oldDef) {
+			if !managedConstraints[uk.Name] {
+				others = append(others, managedConstraintCommand(name, uk.Name))
+			}
+			continue
+		}
+		if hasUnique {
+			fields = append(fields, fmt.Sprintf("drop constraint %s", uk.Name))
+		}
+		fields = append(fields, fmt.Sprintf("add constraint %s %s", uk.Name, uk.UniqueDefinition()))
+		others = append(others, managedConstraintCommand(name, uk.Name))
+	}
+	for _, ukName := range oldUniqueKeys {
+		if !declaredUniqueKeys[ukName] && managedConstraints[ukName] {
+			fields = append(fields, fmt.Sprintf("drop constraint %s", ukName))
+		}
+	}
+
+	// only foreign keys created by EnsureTable are dropped when they are no longer declared
+	declaredForeignKeys := map[string]bool{}
+	for _, fk := range tableForeignKeys(obj, cols) {
+		fkName := fk.ConstraintName(name)
+		declaredForeignKeys[fkName] = true
+		oldDef, hasForeignKey := mcs[fkName]
+		if hasForeignKey && sameForeignKey(fk, oldDef) {
+			if !managedConstraints[fkName] {
+				others = append(others, managedConstraintCommand(name, fkName))
 			}
-			fields = append(fields, fmt.Sprintf("add %s %s null", strings.ToLower(fieldName), fieldType))
+			continue
+		}
+		if hasForeignKey {
+			fields = append(fields, fmt.Sprintf("drop constraint %s", fkName))
+		}
+		fields = append(fields, fmt.Sprintf("add constraint %s %s", fkName, fk.Definition()))
+		others = append(others, managedConstraintCommand(name, fkName))
+	}
+	for _, fkName := range oldForeignKeys {
+		if !declaredForeignKeys[fkName] && managedConstraints[fkName] {
+			fields = append(fields, fmt.Sprintf("drop constraint %s", fkName))
 		}
 	}
 
-	if !hasChange {
+	if len(fields) == 0 && len(others) == 0 {
 		return res, nil
 	}
-	res = append(res, fmt.Sprintf(tableUpdateCommand, name, strings.Join(fields, ",\n")))
+	res = append(res, prerequisites...)
+	if len(fields) > 0 {
+		res = append(res, fmt.Sprintf(tableUpdateCommand, name, strings.Join(fields, ",\n")))
+	}
+	res = append(res, others...)
 
 	for _, mf := range mfs {
 		if !mf.GetBool("included") {
@@ -336,6 +581,32 @@ func (c *Connection) DisableTx(disable bool) {
 	c.txIsDisabled = disable
 }
 
+// AllowLossyAlter allows EnsureTable to change column type into narrower type that might lose data,
+// ie: varchar(50) to varchar(20), bigint to integer or varchar to integer. It is refused by default
+func (c *Connection) AllowLossyAlter(allow bool) {
+	c.lossyAlterIsAllowed = allow
+}
+
+// AllowSetNotNull allows EnsureTable to make existing nullable column NOT NULL, its NULL values are filled with the
+// column default or zero value of its type. It is refused by default, so existing data is never changed implicitly
+func (c *Connection) AllowSetNotNull(allow bool) {
+	c.setNotNullIsAllowed = allow
+}
+
+// txConnection returns connection sharing the pool of c which commands run inside tx, so a private transaction is
+// used through the connection API without touching transaction of c
+func (c *Connection) txConnection(tx *sql.Tx) *Connection {
+	tc := new(Connection)
+	tc.SetThis(tc)
+	tc.ServerInfo = c.ServerInfo
+	tc.db = c.db
+	tc.tx = tx
+	tc.txIsDisabled = c.txIsDisabled
+	tc.timeZone = c.timeZone
+	tc.location = c.location
+	return tc
+}
+
 func (c *Connection) IsTx() bool {
 	return c.tx != nil
 }
@@ -358,35 +629,29 @@ func (c *Connection) EnsureIndex(tableName, idxName string, isUnique bool, field
 	}
 
 	var (
-		jsonbIndex bool
+		ginIndex bool
 	)
 
-	for idx, field := range fields {
-		if strings.Index(field, ".") > 0 {
-			fieldParts := strings.Split(field, ".")
-			if len(fieldParts) > 1 {
-				jsonbIndex = true
-			}
+	// full text search column is indexed using gin
+	tsvectorColumns := []codekit.M{}
+	cmdGetTSVector := fmt.Sprintf("SELECT column_name FROM information_schema.columns WHERE table_name = '%s' AND data_type = 'tsvector'",
+		CleanupSQL(tableBaseName(tableName)))
+	c.Cursor(dbflex.SQL(cmdGetTSVector), nil).Fetchs(&tsvectorColumns, 0).Close()
 
-			for fpIndex, fp := range fieldParts {
-				if fpIndex > 0 {
-					fp = fmt.Sprintf("'%s'", fp)
-				}
-
-				if fpIndex == len(fieldParts)-2 {
-					fieldParts[fpIndex] = fmt.Sprintf("%s->>", fp)
-				} else if fpIndex < len(fieldParts)-2 {
-					fieldParts[fpIndex] = fmt.Sprintf("%s->", fp)
-				} else {
-					fieldParts[fpIndex] = fp
-				}
+	for idx, field := range fields {
+		if isJSONPathField(field, tableName) {
+			ginIndex = true
+			fields[idx] = fmt.Sprintf("(%s) gin_trgm_ops", jsonPathExpr(field, true))
+			continue
+		}
+		for _, col := range tsvectorColumns {
+			if strings.EqualFold(col.GetString("column_name"), field) {
+				ginIndex = true
 			}
-
-			fields[idx] = fmt.Sprintf("(%s) gin_trgm_ops", strings.Join(fieldParts, ""))
 		}
 	}
 
-	if jsonbIndex {
+	if ginIndex {
 		tableName = fmt.Sprintf("%s USING gin", tableName)
 	}
 
@@ -0,0 +1,161 @@
+package flexpg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/sebarcode/codekit"
+)
+
+// ConfigKeyCount is key of Cursor input to choose how Count is computed, its value is one of CountExact,
+// CountEstimate or CountWindow, ie: conn.Cursor(cmd, codekit.M{flexpg.ConfigKeyCount: flexpg.CountWindow})
+const ConfigKeyCount = "count"
+
+// Count modes of a cursor
+const (
+	// CountExact runs count(*) of the command, it is the default
+	CountExact = "exact"
+	// CountEstimate uses statistics of the table, or row estimation of the query plan when the command has filter
+	CountEstimate = "estimate"
+	// CountWindow adds count(*) OVER() into the command, so count is returned with the rows in one round trip.
+	// Count is known once the first row is fetched, the added column is not returned into the fetched rows. Rows are
+	// only fetched into struct or map
+	CountWindow = "window"
+)
+
+// WindowCountColumn is name of the column added by CountWindow
+const WindowCountColumn = "flexpg_total_count"
+
+var selectPrefixRegex = regexp.MustCompile(`(?is)^\s*select\s+`)
+
+// sqlCountCommand wraps a command as subquery to be counted
+func sqlCountCommand(cmdtxt string) string {
+	return fmt.Sprintf("SELECT count(*) AS Count FROM (%s) AS flexpg_count", strings.TrimRight(strings.TrimSpace(cmdtxt), ";"))
+}
+
+// windowCountCommand adds count(*) OVER() as the first column of a select command
+func windowCountCommand(cmdtxt string) (string, error) {
+	loc := selectPrefixRegex.FindStringIndex(cmdtxt)
+	if loc == nil {
+		return "", errors.New("window count is only supported for select command")
+	}
+	if strings.HasPrefix(strings.ToLower(cmdtxt[loc[1]:]), "distinct") {
+		return "", errors.New("window count is not supported for select distinct")
+	}
+	return cmdtxt[:loc[1]] + "count(*) OVER() AS " + WindowCountColumn + ", " + cmdtxt[loc[1]:], nil
+}
+
+// setCountMode sets how count of the cursor is computed based on the cursor input
+func (q *Query) setCountMode(cursor *Cursor, in codekit.M, cmdtxt string) (string, error) {
+	cursor.countMode = in.GetString(ConfigKeyCount)
+	tablename := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	filter, _ := q.Config(dbflex.ConfigKeyFilter, nil).(*dbflex.Filter)
+
+	switch cursor.countMode {
+	case "", CountExact:
+	case CountEstimate:
+		cursor.estimateTable = tablename
+		cursor.estimateCommand = cmdtxt
+		if tablename != "" {
+			cursor.estimateCommand = "SELECT 1 FROM " + tablename
+			if filter != nil {
+				cursor.estimateTable = ""
+				where, err := q.BuildFilter(filter)
+				if err != nil {
+					return cmdtxt, err
+				}
+				cursor.estimateCommand += fmt.Sprintf(" WHERE %v", where)
+			}
+		}
+	case CountWindow:
+		cursor.windowCount = -1
+		return windowCountCommand(cmdtxt)
+	default:
+		return cmdtxt, fmt.Errorf("invalid count mode: %s", cursor.countMode)
+	}
+	return cmdtxt, nil
+}
+
+// Count returns number of rows of the cursor following its count mode
+func (c *Cursor) Count() int {
+	switch c.countMode {
+	case CountEstimate:
+		n, err := c.EstimatedCount()
+		if err != nil {
+			c.SetError(err)
+			return 0
+		}
+		return n
+
+	case CountWindow:
+		if c.windowCount >= 0 {
+			return c.windowCount
+		}
+	}
+	return c.Cursor.Count()
+}
+
+// EstimatedCount returns estimated number of rows of the cursor, from pg_class.reltuples when the cursor reads whole
+// table, otherwise from row estimation of its query plan. Table without statistics is counted exactly
+func (c *Cursor) EstimatedCount() (int, error) {
+	if c.conn == nil {
+		return 0, errors.New("cursor has no connection")
+	}
+	if c.estimateTable != "" {
+		n, err := c.conn.EstimateCount(c.estimateTable)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		// reltuples of table which has never been analyzed is -1, or 0 before PostgreSQL 14, so it is counted exactly
+		return c.Cursor.Count(), nil
+	}
+	return c.conn.estimatePlanRows(c.estimateCommand)
+}
+
+// EstimateCount returns estimated number of rows of a table from its statistics, it returns -1 (or 0 before
+// PostgreSQL 14) when the table has never been analyzed
+func (c *Connection) EstimateCount(tableName string) (int, error) {
+	var n float64
+	cmdtxt := fmt.Sprintf("SELECT reltuples FROM pg_class WHERE oid = '%s'::regclass", CleanupSQL(tableName))
+	if err := c.queryRow(cmdtxt).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s. SQL Command: %s", err.Error(), cmdtxt)
+	}
+	return int(n), nil
+}
+
+// estimatePlanRows returns number of rows estimated by query plan of a command
+func (c *Connection) estimatePlanRows(cmdtxt string) (int, error) {
+	if cmdtxt == "" {
+		return 0, errors.New("no command")
+	}
+	explain := "EXPLAIN (FORMAT JSON) " + cmdtxt
+	var out string
+	if err := c.queryRow(explain).Scan(&out); err != nil {
+		return 0, fmt.Errorf("%s. SQL Command: %s", err.Error(), explain)
+	}
+	plans := []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		}
+	}{}
+	if err := json.Unmarshal([]byte(out), &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("unable to read query plan: %s", out)
+	}
+	return int(plans[0].Plan.PlanRows), nil
+}
+
+func (c *Connection) queryRow(cmdtxt string) *sql.Row {
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	if c.IsTx() {
+		return c.tx.QueryRow(cmdtxt)
+	}
+	return c.db.QueryRow(cmdtxt)
+}
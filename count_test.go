@@ -0,0 +1,48 @@
+package flexpg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCount(t *testing.T) {
+	cv.Convey("count commands", t, func() {
+		cv.So(sqlCountCommand("select * from orders where amount > 0;"), cv.ShouldEqual,
+			"SELECT count(*) AS Count FROM (select * from orders where amount > 0) AS flexpg_count")
+
+		cmd, err := windowCountCommand("SELECT id,name FROM orders ORDER BY id LIMIT 10")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmd, cv.ShouldEqual, "SELECT count(*) OVER() AS flexpg_total_count, id,name FROM orders ORDER BY id LIMIT 10")
+
+		_, err = windowCountCommand("select distinct name from orders")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = windowCountCommand("with x as (select 1) select * from x")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		cv.Convey("count is read from fetched row", func() {
+			cur := new(Cursor)
+			cur.countMode = CountWindow
+			cur.windowCount = -1
+			cur.columns = []Column{{Name: WindowCountColumn, DBType: "int8"}, {Name: "id", DBType: "int4"}}
+
+			type order struct {
+				ID int
+			}
+			o := order{}
+			cv.So(cur.decodeRow([]interface{}{int64(125), int64(1)}, reflect.ValueOf(&o)), cv.ShouldBeNil)
+			cv.So(o.ID, cv.ShouldEqual, 1)
+			cv.So(cur.Count(), cv.ShouldEqual, 125)
+
+			m := codekit.M{}
+			cv.So(cur.decodeRow([]interface{}{int64(130), int64(2)}, reflect.ValueOf(&m)), cv.ShouldBeNil)
+			cv.So(m, cv.ShouldResemble, codekit.M{"id": 2})
+			cv.So(cur.Count(), cv.ShouldEqual, 130)
+
+			cv.So(cur.delegateError(), cv.ShouldNotBeNil)
+			cv.So(new(Cursor).delegateError(), cv.ShouldBeNil)
+		})
+	})
+}
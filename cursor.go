@@ -1,14 +1,15 @@
 package flexpg
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
+	"git.kanosolution.net/kano/dbflex"
 	"git.kanosolution.net/kano/dbflex/drivers/rdbms"
 	"github.com/sebarcode/codekit"
 )
@@ -16,9 +17,353 @@ import (
 // Cursor represent cursor object. Inherits Cursor object of rdbms drivers and implementation of dbflex.ICursor
 type Cursor struct {
 	rdbms.Cursor
+	location *time.Location
+
+	rows    *sql.Rows
+	columns []Column
+
+	// stream is set when rows are fetched by batch from server side cursor
+	stream    *serverCursor
+	batchRows int
+
+	conn            *Connection
+	countMode       string
+	estimateTable   string
+	estimateCommand string
+	windowCount     int
+}
+
+// SetFetcher sets rows fetched by the cursor and reads metadata of its columns
+func (c *Cursor) SetFetcher(rows *sql.Rows) error {
+	columns, err := newColumns(rows)
+	if err != nil {
+		return err
+	}
+	c.columns = columns
+	c.rows = rows
+	return c.Cursor.SetFetcher(rows)
+}
+
+// Columns returns metadata of result columns: name, database type, nullability, length, precision and scale
+func (c *Cursor) Columns() []Column {
+	return c.columns
+}
+
+// Fetch reads next row into obj, which is pointer of a struct or a map. The row is scanned by the cursor, so each value
+// is casted knowing its column
+func (c *Cursor) Fetch(obj interface{}) dbflex.ICursor {
+	dest := reflect.ValueOf(obj)
+	if c.rows == nil || c.Error() != nil {
+		return c.Cursor.Fetch(obj)
+	}
+	if !isRowDestination(dest.Type()) {
+		if err := c.delegateError(); err != nil {
+			c.SetError(err)
+			return c
+		}
+		return c.Cursor.Fetch(obj)
+	}
+	hasRow, err := c.nextRow()
+	if err != nil {
+		c.SetError(err)
+		return c
+	}
+	if !hasRow {
+		// end of rows is reported by rdbms cursor
+		return c.Cursor.Fetch(obj)
+	}
+	if err := c.scanRow(dest); err != nil {
+		c.SetError(err)
+	}
+	return c
+}
+
+// Fetchs reads up to n rows into obj, which is pointer of slice of struct or map. All rows are read when n is 0
+func (c *Cursor) Fetchs(obj interface{}, n int) dbflex.ICursor {
+	dest := reflect.ValueOf(obj)
+	if c.rows == nil || c.Error() != nil {
+		return c.Cursor.Fetchs(obj, n)
+	}
+	if dest.Kind() != reflect.Ptr || dest.Elem().Kind() != reflect.Slice {
+		if err := c.delegateError(); err != nil {
+			c.SetError(err)
+			return c
+		}
+		return c.Cursor.Fetchs(obj, n)
+	}
+	elemType := dest.Elem().Type().Elem()
+	itemType := elemType
+	if itemType.Kind() != reflect.Ptr {
+		itemType = reflect.PtrTo(elemType)
+	}
+	if !isRowDestination(itemType) {
+		if err := c.delegateError(); err != nil {
+			c.SetError(err)
+			return c
+		}
+		return c.Cursor.Fetchs(obj, n)
+	}
+
+	res := reflect.MakeSlice(dest.Elem().Type(), 0, 0)
+	for n == 0 || res.Len() < n {
+		hasRow, err := c.nextRow()
+		if err != nil {
+			c.SetError(err)
+			return c
+		}
+		if !hasRow {
+			break
+		}
+		item := reflect.New(itemType.Elem())
+		if err := c.scanRow(item); err != nil {
+			c.SetError(err)
+			return c
+		}
+		if elemType.Kind() == reflect.Ptr {
+			res = reflect.Append(res, item)
+		} else {
+			res = reflect.Append(res, item.Elem())
+		}
+	}
+	dest.Elem().Set(res)
+	return c
+}
+
+// delegateError returns error if rows could not be fetched by rdbms cursor, which reads rows of destination other than
+// struct or map. It neither follows batches of streaming cursor nor strips column added by window count
+func (c *Cursor) delegateError() error {
+	switch {
+	case c.stream != nil:
+		return errors.New("streaming cursor only fetches into struct or map")
+	case c.countMode == CountWindow:
+		return errors.New("cursor with window count only fetches into struct or map")
+	}
+	return nil
+}
+
+// nextRow advances to next row, next batch of streaming cursor is fetched when rows of current batch are read
+func (c *Cursor) nextRow() (bool, error) {
+	for {
+		if c.rows.Next() {
+			c.batchRows++
+			return true, nil
+		}
+		if err := c.rows.Err(); err != nil {
+			return false, err
+		}
+		if c.stream == nil || c.stream.done {
+			return false, nil
+		}
+		if c.batchRows < c.stream.batchSize {
+			c.stream.done = true
+			return false, nil
+		}
+
+		rows, err := c.stream.fetch()
+		if err != nil {
+			return false, err
+		}
+		c.rows.Close()
+		c.rows = rows
+		c.batchRows = 0
+		// rdbms cursor reports end of rows, so it reads the current batch as well
+		if err = c.Cursor.SetFetcher(rows); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Close closes the cursor, server side cursor of streaming cursor is closed as well
+func (c *Cursor) Close() error {
+	if c.rows != nil {
+		c.rows.Close()
+	}
+	err := c.Cursor.Close()
+	if c.stream != nil {
+		if streamErr := c.stream.close(); err == nil {
+			err = streamErr
+		}
+	}
+	return err
+}
+
+// isRowDestination returns true if a row could be decoded into t: pointer of struct, map keyed by string or pointer of it
+func isRowDestination(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Map {
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Interface
+	}
+	if t.Kind() != reflect.Ptr {
+		return false
+	}
+	t = t.Elem()
+	switch t.Kind() {
+	case reflect.Struct:
+		return t != reflect.TypeOf(time.Time{})
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Interface
+	}
+	return false
+}
+
+// scanRow scans current row and decodes it into dest
+func (c *Cursor) scanRow(dest reflect.Value) error {
+	values := make([]interface{}, len(c.columns))
+	ptrs := make([]interface{}, len(values))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := c.rows.Scan(ptrs...); err != nil {
+		return err
+	}
+	return c.decodeRow(values, dest)
+}
+
+// decodeRow casts values of a row into dest by their columns. Map receives every column, struct receives columns
+// which have a matching field
+func (c *Cursor) decodeRow(values []interface{}, dest reflect.Value) error {
+	if len(values) != len(c.columns) {
+		return fmt.Errorf("row has %d values, cursor has %d columns", len(values), len(c.columns))
+	}
+	target := dest
+	if target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+
+	var fields map[string][]int
+	if target.Kind() == reflect.Struct {
+		fields = columnFields(target.Type())
+	} else if target.IsNil() {
+		target.Set(reflect.MakeMap(target.Type()))
+	}
+
+	for i := range c.columns {
+		col := &c.columns[i]
+		if col.Name == WindowCountColumn {
+			if n, ok := values[i].(int64); ok {
+				c.windowCount = int(n)
+			}
+			// the column is added by CountWindow, it is not selected by the caller
+			continue
+		}
+
+		if fields == nil {
+			v, err := c.castValue(col, values[i], nil)
+			if err != nil {
+				return err
+			}
+			item := reflect.Zero(target.Type().Elem())
+			if v != nil {
+				item = reflect.ValueOf(v)
+			}
+			target.SetMapIndex(reflect.ValueOf(col.Name).Convert(target.Type().Key()), item)
+			continue
+		}
+
+		index, ok := fields[strings.ToLower(col.Name)]
+		if !ok {
+			continue
+		}
+		field := fieldByIndexAlloc(target, index)
+		if !field.IsValid() {
+			continue
+		}
+		v, err := c.castValue(col, values[i], field.Type())
+		if err != nil {
+			return err
+		}
+		if err = setFieldValue(field, v); err != nil {
+			return &CastError{Column: col.Name, Value: values[i], Type: field.Type(), Err: err}
+		}
+	}
+	return nil
+}
+
+// columnFields returns index of struct fields by their column name, following naming of tableColumns.
+// Field of the outer struct takes precedence over promoted field of an embedded struct
+func columnFields(t reflect.Type) map[string][]int {
+	res := map[string][]int{}
+	var walk func(t reflect.Type, prefix []int, depth int, depths map[string]int)
+	walk = func(t reflect.Type, prefix []int, depth int, depths map[string]int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			alias := fieldAlias(f)
+			if alias == "-" {
+				continue
+			}
+			index := append(append([]int{}, prefix...), i)
+			if isFlattenedField(f) {
+				// nil embedded pointer of unexported type could not be allocated
+				if f.IsExported() || f.Type.Kind() != reflect.Ptr {
+					walk(indirectType(f.Type), index, depth+1, depths)
+				}
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if alias != "" {
+				name = alias
+			}
+			name = strings.ToLower(name)
+			if d, exist := depths[name]; exist && d <= depth {
+				continue
+			}
+			depths[name] = depth
+			res[name] = index
+		}
+	}
+	walk(t, nil, 0, map[string]int{})
+	return res
+}
+
+// fieldByIndexAlloc returns nested field, nil embedded pointers on its path are allocated. Invalid value is returned
+// when nil embedded pointer could not be allocated, ie: pointer of unexported type
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
 }
 
+// setFieldValue sets casted value into a struct field, nil is set as zero value
+func setFieldValue(field reflect.Value, v interface{}) error {
+	if v == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type().AssignableTo(field.Type()):
+		field.Set(rv)
+	case rv.Type().ConvertibleTo(field.Type()) && rv.Kind() != reflect.String && field.Kind() != reflect.String:
+		field.Set(rv.Convert(field.Type()))
+	default:
+		return fmt.Errorf("unable to set %s into %s", rv.Type().String(), field.Type().String())
+	}
+	return nil
+}
+
+// CastValue converts value returned by database driver into refType
 func (c *Cursor) CastValue(value interface{}, refType reflect.Type) (interface{}, error) {
+	return c.castValue(nil, value, refType)
+}
+
+// castValue converts value of a column into refType. col is nil when the column is not known, value of known column
+// is decoded by its database type when refType is nil
+func (c *Cursor) castValue(col *Column, value interface{}, refType reflect.Type) (interface{}, error) {
 	var d interface{}
 	var err error
 
@@ -28,26 +373,24 @@ func (c *Cursor) CastValue(value interface{}, refType reflect.Type) (interface{}
 	}
 
 	func() {
-		if r := recover(); r != nil {
-			err = errors.New(r.(string))
-		}
+		defer func() {
+			if r := recover(); r != nil {
+				err = &CastError{Value: value, Type: refType, Err: fmt.Errorf("%v", r)}
+			}
+		}()
 
-		valueTypeString := ""
-		rv := reflect.ValueOf(value)
-		rvk := rv.Kind()
-		switch rvk {
-		case reflect.Invalid:
-			d = value
+		if value == nil {
+			d = castNull(refType)
+			return
+		}
 
-		default:
-			if rv.IsZero() {
-				valueTypeString = ""
-			} else {
-				valueTypeString = rv.Type().String()
+		if typeName == "" && col != nil {
+			var ok bool
+			if d, ok, err = castByColumnType(value, col.DBType); ok {
+				return
 			}
 		}
-
-		if typeName == "" && valueTypeString != "" {
+		if typeName == "" {
 			switch v := value.(type) {
 			case int:
 				d = v
@@ -84,7 +427,20 @@ func (c *Cursor) CastValue(value interface{}, refType reflect.Type) (interface{}
 		}
 	}()
 
-	return d, err
+	if err != nil {
+		castErr, ok := err.(*CastError)
+		if !ok {
+			castErr = &CastError{Value: value, Type: refType, Err: err}
+		}
+		if col != nil {
+			castErr.Column = col.Name
+		}
+		return nil, castErr
+	}
+	if c.location != nil {
+		d = timeInLocation(d, c.location)
+	}
+	return d, nil
 }
 
 func processByTypeName(value interface{}, refType reflect.Type, typeName string) (interface{}, error) {
@@ -97,6 +453,25 @@ func processByTypeName(value interface{}, refType reflect.Type, typeName string)
 	if rv.Kind() == reflect.Invalid {
 		return value, nil
 	}
+	if v, ok, err := registeredFromDB(value, refType); ok {
+		return v, err
+	}
+	if isScanner(refType) {
+		return scanValue(value, refType)
+	}
+	if refType != nil {
+		if isScalarType(refType) {
+			if refType == rv.Type() {
+				return value, nil
+			}
+			return castScalar(value, refType)
+		}
+		if refType.Kind() == reflect.Ptr && typeName != "*time.Time" {
+			if elemKind := refType.Elem().Kind(); elemKind != reflect.Struct && elemKind != reflect.Map && elemKind != reflect.Slice {
+				return castPtr(value, refType)
+			}
+		}
+	}
 	str := ""
 	refTypeString := rv.Type().String()
 	if refTypeString == typeName {
@@ -108,7 +483,7 @@ func processByTypeName(value interface{}, refType reflect.Type, typeName string)
 	} else if refTypeString != "interface{}" && strings.HasPrefix(refTypeString, "int") {
 		str = fmt.Sprintf("%d", value)
 	} else if strings.HasPrefix(refTypeString, "float") {
-		str = fmt.Sprintf("%f", value)
+		str = floatSQLValue(rv.Float(), rv.Type().Bits())
 	} else if refTypeString == "time.Time" || refTypeString == "*time.Time" {
 		str = fmt.Sprintf("%s", value)
 	} else {
@@ -131,21 +506,18 @@ func processByTypeName(value interface{}, refType reflect.Type, typeName string)
 			d = m
 		}
 
-	case "int", "int8", "int16", "int32":
-		d, err = strconv.Atoi(str)
-
 	case "time.Time", "*time.Time":
-		dateFormat := "yyyy-MM-dd HH:mm:ss TH"
-		d = codekit.String2Date(str, dateFormat)
-
-	case "float32":
-		d, err = strconv.ParseFloat(str, 32)
-
-	case "float64":
-		d, err = strconv.ParseFloat(str, 64)
-
-	case "string":
-		d = str
+		var dt time.Time
+		if t, ok := value.(time.Time); ok {
+			dt = t
+		} else if dt, err = parseTime(str); err != nil {
+			return d, err
+		}
+		if typeName == "*time.Time" {
+			d = &dt
+		} else {
+			d = dt
+		}
 
 	default:
 		if refType == nil {
@@ -180,6 +552,9 @@ func processByTypeName(value interface{}, refType reflect.Type, typeName string)
 			} else {
 				d = reflect.ValueOf(refTarget).Elem().Interface()
 			}
+		} else if isSlice && strings.HasPrefix(str, "{") {
+			// native postgres array
+			return castArray(str, refType)
 		} else if isSlice {
 			refArray := createPtrFromType(refSlice).Interface()
 			err = json.Unmarshal([]byte(str), refArray)
@@ -0,0 +1,303 @@
+package flexpg
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTimestamp(t *testing.T) {
+	cv.Convey("writing timestamp", t, func() {
+		jakarta := time.FixedZone("WIB", 7*3600)
+		dt := time.Date(2024, 3, 1, 10, 20, 30, 123456789, jakarta)
+		cv.So(tsValue(dt), cv.ShouldEqual, "'2024-03-01 10:20:30.123457+07:00:00'")
+		cv.So(tsValue(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)), cv.ShouldEqual, "'2024-03-01 00:00:00+00:00:00'")
+
+		cv.Convey("reading timestamp", func() {
+			cases := map[string]time.Time{
+				"2024-03-01 10:20:30.123456+07":    time.Date(2024, 3, 1, 3, 20, 30, 123456000, time.UTC),
+				"2024-03-01 10:20:30.123456+05:30": time.Date(2024, 3, 1, 4, 50, 30, 123456000, time.UTC),
+				"2024-03-01 10:20:30":              time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC),
+				"2024-03-01":                       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			}
+			for str, expected := range cases {
+				dt, err := parseTime(str)
+				cv.So(err, cv.ShouldBeNil)
+				cv.So(dt.Equal(expected), cv.ShouldBeTrue)
+			}
+
+			_, err := parseTime("not a time")
+			cv.So(err, cv.ShouldNotBeNil)
+
+			cur := new(Cursor)
+			v, err := cur.CastValue(dt, reflect.TypeOf(&time.Time{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v.(*time.Time).Equal(dt), cv.ShouldBeTrue)
+
+			cur.location = time.UTC
+			v, err = cur.CastValue([]byte("2024-03-01 10:20:30.123456+07"), reflect.TypeOf(time.Time{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldEqual, time.Date(2024, 3, 1, 3, 20, 30, 123456000, time.UTC))
+		})
+
+		cv.Convey("session time zone", func() {
+			c := new(Connection)
+			c.Host = "localhost:5432"
+			c.Database = "test"
+			c.SetTimeZone("Etc/GMT+7")
+			cv.So(c.connString(), cv.ShouldEqual, "postgres://localhost:5432/test?TimeZone=Etc%2FGMT%2B7")
+		})
+	})
+}
+
+func TestNull(t *testing.T) {
+	cv.Convey("writing null", t, func() {
+		q := new(Query)
+		name := "joe"
+		var nilTime *time.Time
+		cv.So(q.ValueToSQlValue(nil), cv.ShouldEqual, "NULL")
+		cv.So(q.ValueToSQlValue(nilTime), cv.ShouldEqual, "NULL")
+		cv.So(q.ValueToSQlValue(&name), cv.ShouldEqual, "'joe'")
+		cv.So(q.ValueToSQlValue(sql.NullString{}), cv.ShouldEqual, "NULL")
+		cv.So(q.ValueToSQlValue(sql.NullString{String: "joe", Valid: true}), cv.ShouldEqual, "'joe'")
+		cv.So(q.ValueToSQlValue(sql.NullInt64{Int64: 0, Valid: true}), cv.ShouldEqual, "0")
+
+		cv.Convey("reading null", func() {
+			cur := new(Cursor)
+			v, err := cur.CastValue(nil, reflect.TypeOf(&name))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldBeNil)
+
+			v, err = cur.CastValue(nil, reflect.TypeOf(sql.NullInt64{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, sql.NullInt64{})
+
+			v, err = cur.CastValue(int64(0), reflect.TypeOf(sql.NullInt64{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, sql.NullInt64{Int64: 0, Valid: true})
+
+			v, err = cur.CastValue(int64(7), reflect.TypeOf(sql.NullInt32{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, sql.NullInt32{Int32: 7, Valid: true})
+
+			v, err = cur.CastValue([]byte("joe"), reflect.TypeOf(&name))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(*(v.(*string)), cv.ShouldEqual, "joe")
+
+			v, err = cur.CastValue(int64(0), nil)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldEqual, 0)
+		})
+	})
+}
+
+type money struct {
+	Cents int64
+}
+
+func (m money) Value() (driver.Value, error) {
+	if m.Cents < 0 {
+		return nil, errors.New("negative money")
+	}
+	return fmt.Sprintf("%d.%02d", m.Cents/100, m.Cents%100), nil
+}
+
+func (m *money) Scan(src interface{}) error {
+	str := ""
+	switch v := src.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return fmt.Errorf("unable to scan %T into money", src)
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(str, "%d.%d", &units, &cents); err != nil {
+		return err
+	}
+	m.Cents = units*100 + cents
+	return nil
+}
+
+// jsonDoc is a jsonb wrapper which Valuer returns []byte, Value calls are counted
+type jsonDoc struct {
+	Data  map[string]int
+	calls *int
+}
+
+func (d jsonDoc) Value() (driver.Value, error) {
+	*d.calls++
+	if d.Data == nil {
+		return nil, nil
+	}
+	return json.Marshal(d.Data)
+}
+
+func TestValuerScanner(t *testing.T) {
+	cv.Convey("writing driver.Valuer", t, func() {
+		q := new(Query)
+		cv.So(q.ValueToSQlValue(money{Cents: 1250}), cv.ShouldEqual, "'12.50'")
+		cv.So(q.ValueToSQlValue(&money{Cents: 5}), cv.ShouldEqual, "'0.05'")
+		cv.So(q.valueErr, cv.ShouldBeNil)
+		cv.So(q.ValueToSQlValue(money{Cents: -1}), cv.ShouldEqual, "NULL")
+		cv.So(q.valueErr, cv.ShouldNotBeNil)
+
+		calls := 0
+		cv.So(q.ValueToSQlValue(jsonDoc{Data: map[string]int{"it's": 1}, calls: &calls}), cv.ShouldEqual, `'{"it''s":1}'`)
+		cv.So(calls, cv.ShouldEqual, 1)
+		cv.So(q.ValueToSQlValue(jsonDoc{calls: &calls}), cv.ShouldEqual, "NULL")
+		cv.So(calls, cv.ShouldEqual, 2)
+
+		cv.Convey("reading sql.Scanner", func() {
+			cur := new(Cursor)
+			v, err := cur.CastValue([]byte("12.50"), reflect.TypeOf(money{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, money{Cents: 1250})
+
+			v, err = cur.CastValue([]byte("0.05"), reflect.TypeOf(&money{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, &money{Cents: 5})
+
+			_, err = cur.CastValue(true, reflect.TypeOf(money{}))
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+	})
+}
+
+type level int
+
+type uuidValue [16]byte
+
+func TestCastScalar(t *testing.T) {
+	cv.Convey("casting scalar values", t, func() {
+		cases := []struct {
+			value    interface{}
+			target   interface{}
+			expected interface{}
+		}{
+			{true, false, true},
+			{[]byte("t"), false, true},
+			{[]byte("false"), false, false},
+			{int64(42), int(0), 42},
+			{int64(42), int8(0), int8(42)},
+			{int64(42), int16(0), int16(42)},
+			{int64(42), int32(0), int32(42)},
+			{int64(9007199254740993), int64(0), int64(9007199254740993)},
+			{[]byte("9223372036854775807"), int64(0), int64(9223372036854775807)},
+			{int64(3), level(0), level(3)},
+			{int64(42), uint(0), uint(42)},
+			{int64(255), uint8(0), uint8(255)},
+			{[]byte("18446744073709551615"), uint64(0), uint64(18446744073709551615)},
+			{float64(1.5), float64(0), float64(1.5)},
+			{int64(2), float64(0), float64(2)},
+			{[]byte("12.25"), float32(0), float32(12.25)},
+			{[]byte("abc"), "", "abc"},
+			{int64(7), "", "7"},
+			{[]byte{0x61, 0x62}, []byte{}, []byte("ab")},
+			{`\x6162`, []byte{}, []byte("ab")},
+			{[]byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), uuidValue{},
+				uuidValue{0xa0, 0xee, 0xbc, 0x99, 0x9c, 0x0b, 0x4e, 0xf8, 0xbb, 0x6d, 0x6b, 0xb9, 0xbd, 0x38, 0x0a, 0x11}},
+		}
+
+		cur := new(Cursor)
+		for _, c := range cases {
+			v, err := cur.CastValue(c.value, reflect.TypeOf(c.target))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldResemble, c.expected)
+		}
+
+		cv.Convey("failed casting names the column", func() {
+			failures := []struct {
+				value  interface{}
+				target interface{}
+			}{
+				{int64(300), int8(0)},
+				{int64(-1), uint(0)},
+				{[]byte("abc"), int(0)},
+				{[]byte("maybe"), false},
+				{[]byte("not-a-uuid"), uuidValue{}},
+			}
+			cur.columns = []Column{{Name: "id"}, {Name: "amount"}}
+			for _, f := range failures {
+				_, err := cur.castValue(&cur.columns[1], f.value, reflect.TypeOf(f.target))
+				cv.So(err, cv.ShouldNotBeNil)
+				castErr, ok := err.(*CastError)
+				cv.So(ok, cv.ShouldBeTrue)
+				cv.So(castErr.Column, cv.ShouldEqual, "amount")
+				cv.So(err.Error(), cv.ShouldContainSubstring, "of column amount")
+			}
+		})
+	})
+}
+
+func TestCastByColumnType(t *testing.T) {
+	cv.Convey("casting into map based on column type", t, func() {
+		cur := new(Cursor)
+		cur.columns = []Column{
+			{Name: "doc", DBType: "jsonb"},
+			{Name: "tags", DBType: "_text"},
+			{Name: "amount", DBType: "numeric"},
+			{Name: "photo", DBType: "bytea"},
+			{Name: "name", DBType: "text"},
+			{Name: "note", DBType: "text"},
+		}
+		values := []interface{}{
+			[]byte(`{"a":1,"b":[{"c":true}]}`),
+			[]byte(`{a,"b c"}`),
+			[]byte("12.50"),
+			[]byte{0xff, 0x00},
+			[]byte(`{"a":1}`),
+			nil,
+		}
+
+		m := codekit.M{}
+		cv.So(cur.decodeRow(values, reflect.ValueOf(&m)), cv.ShouldBeNil)
+		cv.So(m, cv.ShouldResemble, codekit.M{
+			"doc":    codekit.M{"a": float64(1), "b": []interface{}{codekit.M{"c": true}}},
+			"tags":   []string{"a", "b c"},
+			"amount": "12.50",
+			"photo":  []byte{0xff, 0x00},
+			"name":   `{"a":1}`,
+			"note":   nil,
+		})
+
+		cv.Convey("struct maps only some columns", func() {
+			type Base struct {
+				Code string
+			}
+			type partial struct {
+				*Base
+				Name   string
+				Amount Decimal
+				Skip   string `json:"-"`
+			}
+			var p partial
+			cv.So(cur.decodeRow(values, reflect.ValueOf(&p)), cv.ShouldBeNil)
+			cv.So(p.Name, cv.ShouldEqual, `{"a":1}`)
+			cv.So(p.Amount, cv.ShouldEqual, Decimal("12.50"))
+
+			cur.columns = append(cur.columns, Column{Name: "code", DBType: "text"}, Column{Name: "skip", DBType: "text"})
+			values = append(values, []byte("A1"), []byte("x"))
+			cv.So(cur.decodeRow(values, reflect.ValueOf(&p)), cv.ShouldBeNil)
+			cv.So(p.Base, cv.ShouldNotBeNil)
+			cv.So(p.Code, cv.ShouldEqual, "A1")
+			cv.So(p.Skip, cv.ShouldEqual, "")
+
+			type wrongType struct {
+				Amount int
+			}
+			err := cur.decodeRow(values, reflect.ValueOf(&wrongType{}))
+			cv.So(err, cv.ShouldNotBeNil)
+			cv.So(err.(*CastError).Column, cv.ShouldEqual, "amount")
+		})
+	})
+}
@@ -1,11 +1,15 @@
 package flexpg_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"github.com/ariefdarmawan/flexpg"
 	"github.com/sebarcode/codekit"
 	"github.com/sebarcode/logger"
 	cv "github.com/smartystreets/goconvey/convey"
@@ -305,15 +309,301 @@ func TestPopulateCommand(t *testing.T) {
 	})
 }
 
+func TestStream(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+
+		cv.Convey("streaming rows by batch", func() {
+			stream, err := conn.(*flexpg.Connection).Stream(dbflex.From(tableName).Select(), 2)
+			cv.So(err, cv.ShouldBeNil)
+			defer stream.Close()
+
+			count := 0
+			data := TestData{}
+			for stream.Next(&data) {
+				cv.So(data.ID, cv.ShouldNotBeBlank)
+				count++
+			}
+			cv.So(stream.Err(), cv.ShouldBeNil)
+			cv.So(count, cv.ShouldBeGreaterThan, 0)
+		})
+
+		cv.Convey("fetching streaming cursor", func() {
+			cur := conn.Cursor(dbflex.From(tableName).Select(), codekit.M{flexpg.ConfigKeyStream: 1})
+			defer cur.Close()
+			cv.So(cur.Error(), cv.ShouldBeNil)
+
+			ms := []codekit.M{}
+			cv.So(cur.Fetchs(&ms, 0).Error(), cv.ShouldBeNil)
+			cv.So(len(ms), cv.ShouldEqual, cur.Count())
+		})
+
+		cv.Convey("fetching streaming cursor row by row over several batches", func() {
+			cv.So(conn.EnsureTable("teststream", []string{"ID"}, new(TestData)), cv.ShouldBeNil)
+			_, err = conn.Execute(dbflex.From("teststream").Delete(), nil)
+			cv.So(err, cv.ShouldBeNil)
+			for i := 1; i <= 5; i++ {
+				data := &TestData{ID: fmt.Sprintf("stream%d", i), Created: time.Now()}
+				_, err = conn.Execute(dbflex.From("teststream").Insert(), codekit.M{}.Set("data", data))
+				cv.So(err, cv.ShouldBeNil)
+			}
+
+			cur := conn.Cursor(dbflex.From("teststream").Select(), codekit.M{flexpg.ConfigKeyStream: 2})
+			defer cur.Close()
+			cv.So(cur.Error(), cv.ShouldBeNil)
+
+			count := 0
+			for {
+				m := codekit.M{}
+				if err := cur.Fetch(&m).Error(); err != nil {
+					cv.So(err.Error(), cv.ShouldNotContainSubstring, "closed")
+					break
+				}
+				count++
+			}
+			cv.So(count, cv.ShouldEqual, 5)
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+
+		queue := flexpg.NewQueue(conn.(*flexpg.Connection), "testjobs")
+		queue.Backoff = func(int) time.Duration { return 0 }
+		cv.So(queue.Ensure(), cv.ShouldBeNil)
+		// jobs left by previous runs would be claimed together with the new one
+		_, err = conn.Execute(dbflex.From("testjobs").Delete(), nil)
+		cv.So(err, cv.ShouldBeNil)
+
+		cv.Convey("enqueue and claim", func() {
+			id, err := queue.Enqueue("mail", codekit.M{"to": "joe"}, &flexpg.EnqueueOptions{MaxAttempts: 2})
+			cv.So(err, cv.ShouldBeNil)
+
+			jobs, err := queue.Claim("mail", 10)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(len(jobs), cv.ShouldBeGreaterThan, 0)
+
+			var job *flexpg.Job
+			for i := range jobs {
+				if jobs[i].ID == id {
+					job = &jobs[i]
+				}
+			}
+			cv.So(job, cv.ShouldNotBeNil)
+			payload := codekit.M{}
+			cv.So(job.UnmarshalPayload(&payload), cv.ShouldBeNil)
+			cv.So(payload.GetString("to"), cv.ShouldEqual, "joe")
+
+			cv.Convey("retry then dead", func() {
+				cv.So(queue.Fail(job, errors.New("smtp down")), cv.ShouldBeNil)
+				jobs, err = queue.Claim("mail", 10)
+				cv.So(err, cv.ShouldBeNil)
+				cv.So(len(jobs), cv.ShouldEqual, 1)
+				cv.So(jobs[0].ID, cv.ShouldEqual, id)
+				cv.So(jobs[0].Attempts, cv.ShouldEqual, 2)
+				cv.So(queue.Fail(&jobs[0], errors.New("smtp down")), cv.ShouldBeNil)
+
+				jobs, err = queue.Claim("mail", 10)
+				cv.So(err, cv.ShouldBeNil)
+				cv.So(len(jobs), cv.ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestQueueConcurrentWorkers(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+
+		queue := flexpg.NewQueue(conn.(*flexpg.Connection), "testjobs")
+		cv.So(queue.Ensure(), cv.ShouldBeNil)
+		_, err = conn.Execute(dbflex.From("testjobs").Delete(), nil)
+		cv.So(err, cv.ShouldBeNil)
+
+		cv.Convey("workers sharing the connection never claim the same job", func() {
+			const jobCount = 40
+			for i := 0; i < jobCount; i++ {
+				_, err := queue.Enqueue("report", codekit.M{"seq": i}, nil)
+				cv.So(err, cv.ShouldBeNil)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			mu := sync.Mutex{}
+			handled := map[int64]int{}
+			total := 0
+			wg := sync.WaitGroup{}
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					queue.Work(ctx, "report", 10*time.Millisecond, func(job *flexpg.Job) error {
+						mu.Lock()
+						defer mu.Unlock()
+						handled[job.ID]++
+						if total++; total == jobCount {
+							cancel()
+						}
+						return nil
+					})
+				}()
+			}
+			wg.Wait()
+
+			cv.So(total, cv.ShouldEqual, jobCount)
+			cv.So(len(handled), cv.ShouldEqual, jobCount)
+			for id, count := range handled {
+				if count != 1 {
+					t.Errorf("job %d is claimed %d times", id, count)
+				}
+			}
+		})
+	})
+}
+
+func TestListenNotify(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+		pg := conn.(*flexpg.Connection)
+
+		subscription, err := pg.Listen("testchannel")
+		cv.So(err, cv.ShouldBeNil)
+		defer subscription.Close()
+
+		cv.Convey("notification is delivered on commit", func() {
+			cv.So(pg.BeginTx(), cv.ShouldBeNil)
+			cv.So(pg.Notify("testchannel", "hello"), cv.ShouldBeNil)
+
+			select {
+			case <-subscription.C():
+				t.Error("notification is received before commit")
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			cv.So(pg.Commit(), cv.ShouldBeNil)
+			select {
+			case n := <-subscription.C():
+				cv.So(n.Channel, cv.ShouldEqual, "testchannel")
+				cv.So(n.Payload, cv.ShouldEqual, "hello")
+			case <-time.After(5 * time.Second):
+				t.Error("notification is not received")
+			}
+		})
+	})
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+		pg := conn.(*flexpg.Connection)
+		key := flexpg.AdvisoryKey("testlock")
+
+		cv.Convey("session lock excludes other sessions", func() {
+			lock, err := pg.AdvisoryLock(context.Background(), key)
+			cv.So(err, cv.ShouldBeNil)
+
+			other, err := pg.TryAdvisoryLock(context.Background(), key)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(other, cv.ShouldBeNil)
+
+			_, err = pg.AdvisoryLockTimeout(context.Background(), key, 100*time.Millisecond)
+			cv.So(err, cv.ShouldEqual, flexpg.ErrLockTimeout)
+
+			cv.So(lock.Unlock(), cv.ShouldBeNil)
+			other, err = pg.TryAdvisoryLock(context.Background(), key)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(other, cv.ShouldNotBeNil)
+			cv.So(other.Unlock(), cv.ShouldBeNil)
+		})
+
+		cv.Convey("transaction lock keeps lock_timeout of the transaction", func() {
+			cv.So(pg.BeginTx(), cv.ShouldBeNil)
+			defer pg.RollBack()
+			_, err := pg.Tx().Exec("SET LOCAL lock_timeout = '7s'")
+			cv.So(err, cv.ShouldBeNil)
+
+			cv.So(pg.TxAdvisoryLockTimeout(key, 500*time.Microsecond), cv.ShouldBeNil)
+			lockTimeout := ""
+			cv.So(pg.Tx().QueryRow("SELECT current_setting('lock_timeout')").Scan(&lockTimeout), cv.ShouldBeNil)
+			cv.So(lockTimeout, cv.ShouldEqual, "7s")
+		})
+	})
+}
+
+func TestEnsureEnum(t *testing.T) {
+	cv.Convey("connecting", t, func() {
+		conn, err := connect()
+		cv.So(err, cv.ShouldBeNil)
+		defer conn.Close()
+		pg := conn.(*flexpg.Connection)
+
+		if conn.HasTable("testtickets") {
+			cv.So(conn.DropTable("testtickets"), cv.ShouldBeNil)
+		}
+		cv.So(pg.BeginTx(), cv.ShouldBeNil)
+		_, err = pg.Tx().Exec("DROP TYPE IF EXISTS testticketstatus")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(pg.Commit(), cv.ShouldBeNil)
+
+		cv.So(pg.EnsureTable("testtickets", []string{"ID"}, new(TestTicket)), cv.ShouldBeNil)
+
+		cv.Convey("adding enum value and column defaulting to it", func() {
+			cv.So(pg.EnsureTable("testtickets", []string{"ID"}, new(TestTicketNew)), cv.ShouldBeNil)
+
+			_, err = conn.Execute(dbflex.From("testtickets").Insert(), codekit.M{}.Set("data", &TestTicket{ID: "t1", Status: "open"}))
+			cv.So(err, cv.ShouldBeNil)
+			tickets := []codekit.M{}
+			err = conn.Cursor(dbflex.From("testtickets").Select(), nil).Fetchs(&tickets, 0).Close()
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(len(tickets), cv.ShouldEqual, 1)
+			cv.So(tickets[0].GetString("stage"), cv.ShouldEqual, "pending")
+		})
+	})
+}
+
+type TestTicketStatus string
+
+func (TestTicketStatus) EnumValues() []string { return []string{"open", "closed"} }
+func (TestTicketStatus) EnumTypeName() string { return "testticketstatus" }
+
+// TestTicketStatusNew is TestTicketStatus with a new value
+type TestTicketStatusNew string
+
+func (TestTicketStatusNew) EnumValues() []string { return []string{"open", "closed", "pending"} }
+func (TestTicketStatusNew) EnumTypeName() string { return "testticketstatus" }
+
+type TestTicket struct {
+	ID     string `db_type:"varchar(32)"`
+	Status TestTicketStatus
+}
+
+type TestTicketNew struct {
+	ID     string `db_type:"varchar(32)"`
+	Status TestTicketStatusNew
+	Stage  TestTicketStatusNew `db_default:"'pending'"`
+}
+
 type TestData struct {
-	ID      string `DBType:"varchar(32)"`
+	ID      string `db_type:"varchar(32)"`
 	Title   string
 	DataDec float64
 	Created time.Time
 }
 
 type TestDataNew struct {
-	ID      string `DBType:"varchar(32)"`
+	ID      string `db_type:"varchar(32)"`
 	Title   string
 	Name    string
 	DataDec float64
@@ -0,0 +1,139 @@
+package flexpg
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Decimal is exact decimal number stored as numeric column. It keeps text of the number, so the value is written and
+// read without the rounding of float64
+type Decimal string
+
+var decimalRegex = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+
+// NewDecimal returns Decimal of a number text, ie: "1250.75"
+func NewDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !decimalRegex.MatchString(s) && s != "NaN" {
+		return "", fmt.Errorf("invalid decimal: %s", s)
+	}
+	return Decimal(s), nil
+}
+
+// String returns text of the decimal, zero value is "0"
+func (d Decimal) String() string {
+	if d == "" {
+		return "0"
+	}
+	return string(d)
+}
+
+// Rat returns the decimal as big.Rat, returns false if it is not a valid number
+func (d Decimal) Rat() (*big.Rat, bool) {
+	return new(big.Rat).SetString(d.String())
+}
+
+// Float64 returns nearest float64 of the decimal
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// floatSQLValue writes float with the shortest representation that reads back to the same value
+func floatSQLValue(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "'NaN'"
+	case math.IsInf(f, 1):
+		return "'Infinity'"
+	case math.IsInf(f, -1):
+		return "'-Infinity'"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+// numericText returns text of numeric value returned by database driver
+func numericText(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unable to cast %T into number", v)
+}
+
+func decimalToSQL(v interface{}) string {
+	return fmt.Sprintf("'%s'::numeric", CleanupSQL(v.(Decimal).String()))
+}
+
+func decimalFromDB(v interface{}) (interface{}, error) {
+	str, err := numericText(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecimal(str)
+}
+
+func bigIntToSQL(v interface{}) string {
+	i := v.(big.Int)
+	return i.String()
+}
+
+func bigIntFromDB(v interface{}) (interface{}, error) {
+	str, err := numericText(v)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := new(big.Int).SetString(str, 10)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast %s into big.Int", str)
+	}
+	return i, nil
+}
+
+func bigFloatToSQL(v interface{}) string {
+	f := v.(big.Float)
+	return fmt.Sprintf("'%s'::numeric", f.Text('g', -1))
+}
+
+func bigFloatFromDB(v interface{}) (interface{}, error) {
+	str, err := numericText(v)
+	if err != nil {
+		return nil, err
+	}
+	// 4 bits for each digit keeps all digits of the number
+	f, ok := new(big.Float).SetPrec(uint(len(str)*4 + 64)).SetString(str)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast %s into big.Float", str)
+	}
+	return f, nil
+}
+
+func bigRatToSQL(v interface{}) string {
+	r := v.(big.Rat)
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	return fmt.Sprintf("(%s::numeric / %s::numeric)", r.Num().String(), r.Denom().String())
+}
+
+func bigRatFromDB(v interface{}) (interface{}, error) {
+	str, err := numericText(v)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast %s into big.Rat", str)
+	}
+	return r, nil
+}
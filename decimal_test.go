@@ -0,0 +1,62 @@
+package flexpg
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecimal(t *testing.T) {
+	cv.Convey("writing exact numbers", t, func() {
+		q := new(Query)
+		cv.So(q.ValueToSQlValue(0.1), cv.ShouldEqual, "0.1")
+		cv.So(q.ValueToSQlValue(1e-9), cv.ShouldEqual, "1e-09")
+		cv.So(q.ValueToSQlValue(float32(0.1)), cv.ShouldEqual, "0.1")
+		cv.So(q.ValueToSQlValue(math.Inf(1)), cv.ShouldEqual, "'Infinity'")
+		cv.So(q.ValueToSQlValue(Decimal("12345678901234567890.123456789")), cv.ShouldEqual, "'12345678901234567890.123456789'::numeric")
+		cv.So(q.ValueToSQlValue(Decimal("")), cv.ShouldEqual, "'0'::numeric")
+
+		i, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		cv.So(q.ValueToSQlValue(i), cv.ShouldEqual, "123456789012345678901234567890")
+		cv.So(q.ValueToSQlValue(big.NewRat(1, 4)), cv.ShouldEqual, "(1::numeric / 4::numeric)")
+
+		_, err := NewDecimal("1.5; drop table x")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		cv.Convey("reading exact numbers", func() {
+			cur := new(Cursor)
+			v, err := cur.CastValue([]byte("12345678901234567890.123456789"), reflect.TypeOf(Decimal("")))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldEqual, Decimal("12345678901234567890.123456789"))
+
+			v, err = cur.CastValue([]byte("123456789012345678901234567890"), reflect.TypeOf(&big.Int{}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v.(*big.Int).Cmp(i), cv.ShouldEqual, 0)
+
+			v, err = cur.CastValue([]byte("0.25"), reflect.TypeOf(big.Rat{}))
+			cv.So(err, cv.ShouldBeNil)
+			r := v.(big.Rat)
+			cv.So(r.Cmp(big.NewRat(1, 4)), cv.ShouldEqual, 0)
+
+			v, err = cur.CastValue(float64(0.1), reflect.TypeOf(float32(0)))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(v, cv.ShouldEqual, float32(0.1))
+		})
+
+		cv.Convey("precision and scale tags", func() {
+			type invoice struct {
+				Amount Decimal `db_precision:"18" db_scale:"2"`
+				Rate   float64 `db_precision:"9"`
+				Total  Decimal
+			}
+			cols, err := tableColumns(invoice{})
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(cols[0].Type, cv.ShouldEqual, "numeric(18,2)")
+			cv.So(cols[1].Type, cv.ShouldEqual, "numeric(9)")
+			cv.So(cols[2].Type, cv.ShouldEqual, "numeric")
+		})
+	})
+}
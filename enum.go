@@ -0,0 +1,128 @@
+package flexpg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/sebarcode/codekit"
+)
+
+// Enum is implemented by named string type which is stored as PostgreSQL enum type. The enum type is named after
+// the Go type in lower case, implement EnumTypeNamer to use other name
+type Enum interface {
+	EnumValues() []string
+}
+
+// EnumTypeNamer is implemented by Enum which type name on database is not the name of its Go type
+type EnumTypeNamer interface {
+	EnumTypeName() string
+}
+
+// enumType is PostgreSQL enum type of a Go type
+type enumType struct {
+	Name   string
+	Values []string
+}
+
+var enumInterface = reflect.TypeOf((*Enum)(nil)).Elem()
+
+// enumOf returns enum type of a Go type, pointer and slice are resolved to type of its element
+func enumOf(t reflect.Type) *enumType {
+	t = indirectType(t)
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = indirectType(t.Elem())
+	}
+	t, _ = sqlNullValueType(t)
+
+	var v interface{}
+	switch {
+	case t.Implements(enumInterface):
+		v = reflect.Zero(t).Interface()
+	case reflect.PtrTo(t).Implements(enumInterface):
+		v = reflect.New(t).Interface()
+	default:
+		return nil
+	}
+
+	et := &enumType{Name: strings.ToLower(t.Name()), Values: v.(Enum).EnumValues()}
+	if namer, ok := v.(EnumTypeNamer); ok && namer.EnumTypeName() != "" {
+		et.Name = strings.ToLower(namer.EnumTypeName())
+	}
+	return et
+}
+
+func enumLabels(values []string) string {
+	labels := make([]string, len(values))
+	for i, value := range values {
+		labels[i] = fmt.Sprintf("'%s'", CleanupSQL(value))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// createCommandForEnums returns commands to create enum types used by columns, and commands to add new values of the
+// existing ones. New value could not be used before the transaction adding it is committed, so added values are
+// committed before the table is created or updated
+func createCommandForEnums(c *Connection, cols []columnDef) (res []string, addValues []string, err error) {
+	ensured := map[string]bool{}
+	for _, col := range cols {
+		if col.Enum == nil || ensured[col.Enum.Name] {
+			continue
+		}
+		ensured[col.Enum.Name] = true
+
+		labels := []codekit.M{}
+		sql := "select e.enumlabel from pg_type t inner join pg_enum e on e.enumtypid=t.oid" +
+			" where t.typname='" + CleanupSQL(col.Enum.Name) + "' order by e.enumsortorder"
+		if e := c.Cursor(dbflex.SQL(sql), nil).Fetchs(&labels, 0).Close(); e != nil {
+			return res, addValues, fmt.Errorf("unable to get enum %s. %s", col.Enum.Name, e.Error())
+		}
+
+		if len(labels) == 0 {
+			res = append(res, fmt.Sprintf("CREATE TYPE %s AS ENUM (%s);", col.Enum.Name, enumLabels(col.Enum.Values)))
+			continue
+		}
+
+		existing := make([]string, len(labels))
+		for i, label := range labels {
+			existing[i] = label.GetString("enumlabel")
+		}
+		for _, value := range col.Enum.Values {
+			if !codekit.HasMember(existing, value) {
+				addValues = append(addValues, fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS '%s';", col.Enum.Name, CleanupSQL(value)))
+			}
+		}
+	}
+	return res, addValues, nil
+}
+
+// addEnumValues executes commands adding enum values in their own transaction, so the values are usable by the
+// following commands. It runs outside of transaction of the connection
+func (c *Connection) addEnumValues(cmdTxts []string) error {
+	if len(cmdTxts) == 0 {
+		return nil
+	}
+	tx, e := c.db.Begin()
+	if e != nil {
+		return e
+	}
+	for _, cmdTxt := range cmdTxts {
+		dbflex.Logger().Info(cmdTxt)
+		if _, e = tx.Exec(cmdTxt); e != nil {
+			tx.Rollback()
+			return fmt.Errorf("error: %s command: %s", e.Error(), cmdTxt)
+		}
+	}
+	return tx.Commit()
+}
+
+// columnFillValue returns value to fill existing rows when a column becomes NOT NULL. Enum column is filled by its first
+// value, since empty string is not a valid enum value
+func columnFillValue(col columnDef) string {
+	ct := parseColumnType(col.Type)
+	if col.Enum != nil && len(col.Enum.Values) > 0 && !strings.HasSuffix(ct.Name, "[]") {
+		return fmt.Sprintf("'%s'::%s", CleanupSQL(col.Enum.Values[0]), col.Enum.Name)
+	}
+	return typeDefaultValue(ct)
+}
@@ -0,0 +1,100 @@
+package flexpg
+
+import (
+	"fmt"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// Filter operators specific to PostgreSQL
+const (
+	// OpArrayAny matches if value is equal to any element of array column
+	OpArrayAny dbflex.FilterOp = "$pgany"
+	// OpArrayContains matches if array column contains all given elements
+	OpArrayContains dbflex.FilterOp = "$pgarraycontains"
+	// OpArrayOverlap matches if array column has any of given elements
+	OpArrayOverlap dbflex.FilterOp = "$pgarrayoverlap"
+	// OpKeysetAfter matches rows placed after given key values, used by keyset pagination
+	OpKeysetAfter dbflex.FilterOp = "$pgkeysetafter"
+	// OpJSONContains matches if jsonb field contains given json value
+	OpJSONContains dbflex.FilterOp = "$pgjsoncontains"
+	// OpJSONHasKey matches if jsonb field has given key
+	OpJSONHasKey dbflex.FilterOp = "$pgjsonhaskey"
+	// OpJSONPathExists matches if given SQL/JSON path returns any item of jsonb field
+	OpJSONPathExists dbflex.FilterOp = "$pgjsonpathexists"
+	// OpJSONPath translates dotted fields of its filters into path of jsonb column, even table qualified one, see JSONPath
+	OpJSONPath dbflex.FilterOp = "$pgjsonpath"
+	// OpTextSearch matches if tsvector field matches web search query, value is TextSearch
+	OpTextSearch dbflex.FilterOp = "$pgtextsearch"
+)
+
+// ArrayAny creates filter value = ANY(field)
+func ArrayAny(field string, value interface{}) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpArrayAny, Value: value}
+}
+
+// ArrayContains creates filter field @> values
+func ArrayContains(field string, values interface{}) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpArrayContains, Value: Array(values)}
+}
+
+// ArrayOverlap creates filter field && values
+func ArrayOverlap(field string, values interface{}) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpArrayOverlap, Value: Array(values)}
+}
+
+// BuildFilter translates PostgreSQL specific operators, other operators are translated by rdbms.Query
+func (q *Query) BuildFilter(f *dbflex.Filter) (interface{}, error) {
+	switch f.Op {
+	case dbflex.OpAnd, dbflex.OpOr:
+		return q.joinFilters(f, q.BuildFilter)
+
+	case OpJSONPath:
+		return q.buildJSONPath(f)
+
+	case OpArrayAny:
+		return fmt.Sprintf("%s = ANY(%s)", q.ValueToSQlValue(f.Value), f.Field), nil
+
+	case OpArrayContains:
+		return fmt.Sprintf("%s @> %s", f.Field, q.ValueToSQlValue(f.Value)), nil
+
+	case OpArrayOverlap:
+		return fmt.Sprintf("%s && %s", f.Field, q.ValueToSQlValue(f.Value)), nil
+
+	case OpKeysetAfter:
+		return q.buildKeysetFilter(f)
+
+	case OpJSONContains, OpJSONHasKey, OpJSONPathExists:
+		return q.buildJSONFilter(f, q.isJSONPathField(f.Field))
+
+	case OpTextSearch:
+		ts, ok := f.Value.(TextSearch)
+		if !ok {
+			ts = TextSearch{Query: fmt.Sprintf("%v", f.Value)}
+		}
+		return fmt.Sprintf("%s @@ %s", f.Field, ts.tsQuery()), nil
+	}
+
+	if q.isJSONPathField(f.Field) {
+		return q.buildJSONPathFilter(f)
+	}
+	return q.Query.BuildFilter(f)
+}
+
+// joinFilters builds items of and / or filter using build
+func (q *Query) joinFilters(f *dbflex.Filter, build func(*dbflex.Filter) (interface{}, error)) (interface{}, error) {
+	items := []string{}
+	for _, item := range f.Items {
+		where, err := build(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, fmt.Sprintf("%v", where))
+	}
+	separator := " AND "
+	if f.Op == dbflex.OpOr {
+		separator = " OR "
+	}
+	return "(" + strings.Join(items, separator) + ")", nil
+}
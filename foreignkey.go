@@ -0,0 +1,154 @@
+package flexpg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var sqlSchemaRegex = regexp.MustCompile(`references[a-z0-9_"]+\.`)
+
+// ForeignKey is foreign key constraint of a table. Empty OnDelete and OnUpdate means NO ACTION
+type ForeignKey struct {
+	Name              string
+	Fields            []string
+	RefTable          string
+	RefFields         []string
+	OnDelete          string
+	OnUpdate          string
+	Deferrable        bool
+	InitiallyDeferred bool
+}
+
+// ForeignKeyProvider is implemented by model that has foreign keys which can't be declared using db_ref tag,
+// ie: composite foreign key. Fields are struct field names or column names, the same way as keys of EnsureTable
+type ForeignKeyProvider interface {
+	ForeignKeys() []ForeignKey
+}
+
+// newColumnForeignKey parses db_ref, db_ondelete, db_onupdate and db_deferrable tags of a field, see EnsureTable
+func newColumnForeignKey(columnName string, tag reflect.StructTag) *ForeignKey {
+	ref := strings.TrimSpace(tag.Get("db_ref"))
+	if ref == "" {
+		return nil
+	}
+
+	fk := &ForeignKey{
+		Fields:    []string{columnName},
+		RefTable:  strings.ToLower(ref),
+		RefFields: []string{"id"},
+		OnDelete:  tag.Get("db_ondelete"),
+		OnUpdate:  tag.Get("db_onupdate"),
+	}
+	if idx := strings.Index(ref, "("); idx > 0 {
+		fk.RefTable = strings.ToLower(strings.TrimSpace(ref[:idx]))
+		fk.RefFields = splitFields(strings.Trim(ref[idx:], "()"))
+	}
+	switch strings.ToLower(tag.Get("db_deferrable")) {
+	case "true":
+		fk.Deferrable = true
+	case "deferred", "initially deferred":
+		fk.Deferrable = true
+		fk.InitiallyDeferred = true
+	}
+	return fk
+}
+
+func splitFields(s string) []string {
+	res := []string{}
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			res = append(res, strings.ToLower(field))
+		}
+	}
+	return res
+}
+
+// ConstraintName returns name of the constraint, default to <table>_<fields>_fkey as postgres does
+func (fk ForeignKey) ConstraintName(tableName string) string {
+	if fk.Name != "" {
+		return strings.ToLower(fk.Name)
+	}
+	return constraintName(tableName, strings.Join(fk.Fields, "_"), "fkey")
+}
+
+// Definition returns constraint definition, written in the same way as pg_get_constraintdef
+func (fk ForeignKey) Definition() string {
+	def := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s)",
+		strings.ToLower(strings.Join(fk.Fields, ", ")),
+		fk.RefTable,
+		strings.ToLower(strings.Join(fk.RefFields, ", ")))
+	if action := foreignKeyAction(fk.OnUpdate); action != "" {
+		def += " ON UPDATE " + action
+	}
+	if action := foreignKeyAction(fk.OnDelete); action != "" {
+		def += " ON DELETE " + action
+	}
+	if fk.Deferrable {
+		def += " DEFERRABLE"
+		if fk.InitiallyDeferred {
+			def += " INITIALLY DEFERRED"
+		}
+	}
+	return def
+}
+
+func foreignKeyAction(action string) string {
+	action = strings.ToUpper(strings.Join(strings.Fields(action), " "))
+	if action == "NO ACTION" {
+		return ""
+	}
+	return action
+}
+
+// sameForeignKey compares definition against the one returned by pg_get_constraintdef, which might qualify
+// referenced table with its schema
+func sameForeignKey(fk ForeignKey, existingDef string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), ""))
+	}
+	def := normalize(fk.Definition())
+	existingDef = normalize(existingDef)
+	if def == existingDef {
+		return true
+	}
+	if !strings.Contains(fk.RefTable, ".") {
+		return def == sqlSchemaRegex.ReplaceAllString(existingDef, "references")
+	}
+	return false
+}
+
+// keyColumnNames resolves fields given as struct field names or column names into column names, the same way as keys
+// of primary key. Unknown field is kept as is
+func keyColumnNames(fields []string, cols []columnDef) []string {
+	res := make([]string, len(fields))
+	for idx, field := range fields {
+		res[idx] = field
+		for _, col := range cols {
+			if strings.EqualFold(field, col.FieldName) || strings.EqualFold(field, col.Name) {
+				res[idx] = col.Name
+				break
+			}
+		}
+	}
+	return res
+}
+
+// tableForeignKeys returns foreign keys declared by tags and ForeignKeyProvider of the model
+func tableForeignKeys(obj interface{}, cols []columnDef) []ForeignKey {
+	res := []ForeignKey{}
+	for _, col := range cols {
+		if col.ForeignKey != nil {
+			res = append(res, *col.ForeignKey)
+		}
+	}
+	if provider, ok := obj.(ForeignKeyProvider); ok {
+		for _, fk := range provider.ForeignKeys() {
+			fk.Fields = keyColumnNames(splitFields(strings.Join(fk.Fields, ",")), cols)
+			fk.RefTable = strings.ToLower(strings.TrimSpace(fk.RefTable))
+			res = append(res, fk)
+		}
+	}
+	return res
+}
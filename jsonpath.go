@@ -0,0 +1,193 @@
+package flexpg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// JSONPath reads dotted fields of filters as path of jsonb column even when its first element is the table of the
+// query, ie: JSONPath(dbflex.Eq("orders.status", "open")) is translated into orders->>'status' = 'open' on table
+// orders. Several filters are joined by AND
+func JSONPath(filters ...*dbflex.Filter) *dbflex.Filter {
+	return &dbflex.Filter{Op: OpJSONPath, Items: filters}
+}
+
+// JSONContains creates filter field @> value, value is marshalled as jsonb. Field could be dotted path of jsonb column
+func JSONContains(field string, value interface{}) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpJSONContains, Value: value}
+}
+
+// JSONHasKey creates filter field ? key, matches if jsonb field has the key at top level
+func JSONHasKey(field string, key string) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpJSONHasKey, Value: key}
+}
+
+// JSONPathExists creates filter jsonb_path_exists(field, path), ie: JSONPathExists("meta", "$.items[*] ? (@.qty > 5)")
+func JSONPathExists(field string, path string) *dbflex.Filter {
+	return &dbflex.Filter{Field: field, Op: OpJSONPathExists, Value: path}
+}
+
+// isJSONPathField returns true if field is dotted path of jsonb column, ie: meta.status. Field qualified by table of
+// the query, its schema or its alias, ie: orders.status on table orders, is a column instead
+func isJSONPathField(field string, tableName string) bool {
+	idx := strings.Index(field, ".")
+	if idx <= 0 {
+		return false
+	}
+	prefix := strings.ToLower(strings.Trim(field[:idx], `"`))
+
+	parts := strings.Fields(strings.ToLower(strings.Replace(tableName, `"`, "", -1)))
+	if len(parts) == 0 {
+		return true
+	}
+	qualifiers := []string{parts[0], tableBaseName(parts[0])}
+	if schemaIdx := strings.Index(parts[0], "."); schemaIdx > 0 {
+		qualifiers = append(qualifiers, parts[0][:schemaIdx])
+	}
+	if len(parts) > 1 {
+		// alias, ie: orders o or orders as o
+		qualifiers = append(qualifiers, parts[len(parts)-1])
+	}
+	for _, qualifier := range qualifiers {
+		if prefix == qualifier {
+			return false
+		}
+	}
+	return true
+}
+
+// isJSONPathField returns true if field is dotted path of jsonb column for the table of the query
+func (q *Query) isJSONPathField(field string) bool {
+	tableName, _ := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	return isJSONPathField(field, tableName)
+}
+
+// jsonPathExpr translates dotted path of jsonb column into json operators, ie: meta.a.b into meta->'a'->>'b'.
+// The last element is read as text when asText is true, otherwise as jsonb
+func jsonPathExpr(field string, asText bool) string {
+	parts := strings.Split(field, ".")
+	sb := strings.Builder{}
+	sb.WriteString(parts[0])
+	for i, part := range parts[1:] {
+		if asText && i == len(parts)-2 {
+			sb.WriteString("->>")
+		} else {
+			sb.WriteString("->")
+		}
+		sb.WriteString(fmt.Sprintf("'%s'", CleanupSQL(part)))
+	}
+	return sb.String()
+}
+
+// jsonPathCast returns type the text of json path is casted into to be compared with the value
+func jsonPathCast(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		if rv.Len() == 0 {
+			return ""
+		}
+		return jsonPathCast(rv.Index(0).Interface())
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "numeric"
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	switch rv.Interface().(type) {
+	case time.Time:
+		return "timestamptz"
+	case Decimal:
+		return "numeric"
+	}
+	return ""
+}
+
+// buildJSONPathFilter translates filter of dotted field into filter of json path expression, text of the path is
+// casted following type of the value so numeric and boolean are compared as is
+func (q *Query) buildJSONPathFilter(f *dbflex.Filter) (interface{}, error) {
+	field := jsonPathExpr(f.Field, true)
+	switch f.Op {
+	case dbflex.OpContains, dbflex.OpStartWith, dbflex.OpEndWith:
+	default:
+		if cast := jsonPathCast(f.Value); cast != "" {
+			field = fmt.Sprintf("(%s)::%s", field, cast)
+		}
+	}
+
+	pathFilter := *f
+	pathFilter.Field = field
+	return q.Query.BuildFilter(&pathFilter)
+}
+
+// jsonValueSQL writes value as jsonb literal
+func jsonValueSQL(v interface{}) (string, error) {
+	if raw, ok := v.(json.RawMessage); ok {
+		return rawJSONToSQL(raw), nil
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("'%s'::jsonb", CleanupSQL(string(bs))), nil
+}
+
+// buildJSONPath builds filters of JSONPath, which dotted fields are path of jsonb column
+func (q *Query) buildJSONPath(f *dbflex.Filter) (interface{}, error) {
+	switch f.Op {
+	case OpJSONPath:
+		if len(f.Items) == 1 {
+			return q.buildJSONPath(f.Items[0])
+		}
+		return q.joinFilters(&dbflex.Filter{Op: dbflex.OpAnd, Items: f.Items}, q.buildJSONPath)
+
+	case dbflex.OpAnd, dbflex.OpOr:
+		return q.joinFilters(f, q.buildJSONPath)
+
+	case OpJSONContains, OpJSONHasKey, OpJSONPathExists:
+		return q.buildJSONFilter(f, true)
+	}
+
+	if strings.Index(f.Field, ".") > 0 {
+		return q.buildJSONPathFilter(f)
+	}
+	return q.BuildFilter(f)
+}
+
+// buildJSONFilter translates jsonb specific operators, dotted field is read as json path when isPath is true
+func (q *Query) buildJSONFilter(f *dbflex.Filter, isPath bool) (interface{}, error) {
+	field := f.Field
+	if isPath && strings.Index(field, ".") > 0 {
+		field = jsonPathExpr(field, false)
+	}
+
+	switch f.Op {
+	case OpJSONContains:
+		value, err := jsonValueSQL(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%s @> %s", field, value), nil
+
+	case OpJSONHasKey:
+		return fmt.Sprintf("%s ? '%s'", field, CleanupSQL(fmt.Sprintf("%v", f.Value))), nil
+
+	case OpJSONPathExists:
+		return fmt.Sprintf("jsonb_path_exists(%s, '%s')", field, CleanupSQL(fmt.Sprintf("%v", f.Value))), nil
+	}
+	return nil, fmt.Errorf("unknown json operator: %s", f.Op)
+}
@@ -0,0 +1,60 @@
+package flexpg
+
+import (
+	"testing"
+	"time"
+
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJSONPathFilter(t *testing.T) {
+	cv.Convey("json path of dotted field", t, func() {
+		cv.So(jsonPathExpr("meta.status", true), cv.ShouldEqual, "meta->>'status'")
+		cv.So(jsonPathExpr("meta.address.city", true), cv.ShouldEqual, "meta->'address'->>'city'")
+		cv.So(jsonPathExpr("meta.address.city", false), cv.ShouldEqual, "meta->'address'->'city'")
+		cv.So(isJSONPathField("meta.status", ""), cv.ShouldBeTrue)
+		cv.So(isJSONPathField("status", ""), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("meta.status", "orders"), cv.ShouldBeTrue)
+		cv.So(isJSONPathField("orders.status", "orders"), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("Orders.status", "sales.orders"), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("sales.orders.status", "sales.orders"), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("o.status", "orders o"), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("o.status", "orders AS o"), cv.ShouldBeFalse)
+		cv.So(isJSONPathField("meta.status", "orders AS o"), cv.ShouldBeTrue)
+
+		cv.So(jsonPathCast("x"), cv.ShouldEqual, "")
+		cv.So(jsonPathCast(10), cv.ShouldEqual, "numeric")
+		cv.So(jsonPathCast(1.5), cv.ShouldEqual, "numeric")
+		cv.So(jsonPathCast(true), cv.ShouldEqual, "boolean")
+		cv.So(jsonPathCast(time.Now()), cv.ShouldEqual, "timestamptz")
+		cv.So(jsonPathCast([]interface{}{1, 2}), cv.ShouldEqual, "numeric")
+
+		cv.Convey("jsonb operators", func() {
+			q := new(Query)
+			where, err := q.BuildFilter(JSONContains("meta", map[string]interface{}{"status": "it's"}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, `meta @> '{"status":"it''s"}'::jsonb`)
+
+			where, err = q.BuildFilter(JSONContains("meta.tags", []string{"a"}))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, `meta->'tags' @> '["a"]'::jsonb`)
+
+			// field qualified by table of the query is a column
+			where, err = q.buildJSONFilter(JSONContains("orders.meta", []string{"a"}), false)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, `orders.meta @> '["a"]'::jsonb`)
+
+			where, err = q.BuildFilter(JSONPath(JSONHasKey("meta.address", "city"), JSONHasKey("orders.meta", "status")))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, "(meta->'address' ? 'city' AND orders->'meta' ? 'status')")
+
+			where, err = q.BuildFilter(JSONHasKey("meta", "status"))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, "meta ? 'status'")
+
+			where, err = q.BuildFilter(JSONPathExists("meta", "$.items[*] ? (@.qty > 5)"))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, "jsonb_path_exists(meta, '$.items[*] ? (@.qty > 5)')")
+		})
+	})
+}
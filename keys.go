@@ -0,0 +1,76 @@
+package flexpg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PrimaryKeyNamer is implemented by model which primary key constraint is not named <table>_pkey
+type PrimaryKeyNamer interface {
+	PrimaryKeyName() string
+}
+
+// tableKey is primary key or unique constraint of one or more columns
+type tableKey struct {
+	Name   string
+	Fields []string
+}
+
+// tablePrimaryKey returns primary key constraint of the table, fields are ordered as given by keys
+func tablePrimaryKey(tableName string, keys []string, obj interface{}, cols []columnDef) tableKey {
+	pk := tableKey{Name: strings.ToLower(tableBaseName(tableName) + "_pkey")}
+	if namer, ok := obj.(PrimaryKeyNamer); ok && namer.PrimaryKeyName() != "" {
+		pk.Name = strings.ToLower(namer.PrimaryKeyName())
+	}
+	for _, key := range keys {
+		for _, col := range cols {
+			if strings.EqualFold(key, col.FieldName) || strings.EqualFold(key, col.Name) {
+				pk.Fields = append(pk.Fields, col.Name)
+				break
+			}
+		}
+	}
+	return pk
+}
+
+// tableUniqueKeys returns unique constraints of the table. Columns with the same db_unique group
+// are put into one constraint
+func tableUniqueKeys(tableName string, cols []columnDef) []tableKey {
+	res := []tableKey{}
+	groupIndexes := map[string]int{}
+	for _, col := range cols {
+		switch col.UniqueGroup {
+		case "":
+			continue
+
+		case "true":
+			res = append(res, tableKey{Name: constraintName(tableName, col.Name, "key"), Fields: []string{col.Name}})
+
+		default:
+			if idx, ok := groupIndexes[col.UniqueGroup]; ok {
+				res[idx].Fields = append(res[idx].Fields, col.Name)
+				continue
+			}
+			groupIndexes[col.UniqueGroup] = len(res)
+			res = append(res, tableKey{Name: constraintName(tableName, col.UniqueGroup, "key"), Fields: []string{col.Name}})
+		}
+	}
+	return res
+}
+
+// PrimaryKeyDefinition returns primary key definition, written in the same way as pg_get_constraintdef
+func (k tableKey) PrimaryKeyDefinition() string {
+	return fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(k.Fields, ", "))
+}
+
+// UniqueDefinition returns unique constraint definition, written in the same way as pg_get_constraintdef
+func (k tableKey) UniqueDefinition() string {
+	return fmt.Sprintf("UNIQUE (%s)", strings.Join(k.Fields, ", "))
+}
+
+func sameKeyDefinition(def, existingDef string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), ""))
+	}
+	return normalize(def) == normalize(existingDef)
+}
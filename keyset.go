@@ -0,0 +1,188 @@
+package flexpg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/sebarcode/codekit"
+)
+
+// keysetValue is value of OpKeysetAfter filter
+type keysetValue struct {
+	Keys   []string
+	Values []interface{}
+}
+
+// KeysetAfter creates filter of rows placed after given key values following order of keys. Key prefixed by "-"
+// is in descending order, the same way as OrderBy, ie: KeysetAfter([]string{"-created", "id"}, lastCreated, lastID)
+func KeysetAfter(keys []string, values ...interface{}) *dbflex.Filter {
+	return &dbflex.Filter{Op: OpKeysetAfter, Value: keysetValue{Keys: keys, Values: values}}
+}
+
+// keysetOrder returns field name of a key and whether it is in descending order
+func keysetOrder(key string) (string, bool) {
+	if strings.HasPrefix(key, "-") {
+		return key[1:], true
+	}
+	return key, false
+}
+
+// buildKeysetFilter translates OpKeysetAfter into row comparison, ie: (a,b) > ('x',1). Keys with mixed order are
+// translated into a > 'x' OR (a = 'x' AND b < 1)
+func (q *Query) buildKeysetFilter(f *dbflex.Filter) (string, error) {
+	ks, ok := f.Value.(keysetValue)
+	if !ok || len(ks.Keys) == 0 || len(ks.Keys) != len(ks.Values) {
+		return "", errors.New("keyset filter should have the same number of keys and values")
+	}
+
+	fields := make([]string, len(ks.Keys))
+	descs := make([]bool, len(ks.Keys))
+	values := make([]string, len(ks.Keys))
+	sameOrder := true
+	for i, key := range ks.Keys {
+		fields[i], descs[i] = keysetOrder(key)
+		values[i] = q.ValueToSQlValue(ks.Values[i])
+		sameOrder = sameOrder && descs[i] == descs[0]
+	}
+
+	op := func(desc bool) string {
+		if desc {
+			return "<"
+		}
+		return ">"
+	}
+	if sameOrder {
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(fields, ","), op(descs[0]), strings.Join(values, ",")), nil
+	}
+
+	items := make([]string, len(fields))
+	for i := range fields {
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", fields[j], values[j]))
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", fields[i], op(descs[i]), values[i]))
+		items[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+	return "(" + strings.Join(items, " OR ") + ")", nil
+}
+
+// PageToken encodes key values of the last row of a page into opaque continuation token
+func PageToken(values ...interface{}) (string, error) {
+	bs, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// parsePageToken decodes continuation token into key values
+func parsePageToken(token string, keyCount int) ([]interface{}, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %s", err.Error())
+	}
+	values := []interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(string(bs)))
+	decoder.UseNumber()
+	if err = decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid page token: %s", err.Error())
+	}
+	if len(values) != keyCount {
+		return nil, fmt.Errorf("invalid page token: expecting %d keys, got %d", keyCount, len(values))
+	}
+	return values, nil
+}
+
+// Page reads a page of a table into dest, pointer of slice of struct or codekit.M, using keyset pagination.
+// Rows are ordered by keys, which should identify a row uniquely, ie: []string{"-created", "id"}. Token is empty
+// for the first page, next token is returned as long as there are more rows after the page
+func (c *Connection) Page(tableName string, filter *dbflex.Filter, keys []string, token string, take int, dest interface{}) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New("keys are required")
+	}
+	if take <= 0 {
+		return "", errors.New("take should be greater than zero")
+	}
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.Elem().Kind() != reflect.Slice {
+		return "", errors.New("destination should be pointer of a slice")
+	}
+
+	where := filter
+	if token != "" {
+		values, err := parsePageToken(token, len(keys))
+		if err != nil {
+			return "", err
+		}
+		after := KeysetAfter(keys, values...)
+		if where == nil {
+			where = after
+		} else {
+			where = dbflex.And(where, after)
+		}
+	}
+
+	// one more row is read to know whether there is next page
+	cmd := dbflex.From(tableName).Select().OrderBy(keys...).Take(take + 1)
+	if where != nil {
+		cmd.Where(where)
+	}
+	if err := c.Cursor(cmd, nil).Fetchs(dest, 0).Close(); err != nil {
+		return "", err
+	}
+
+	rows := destValue.Elem()
+	if rows.Len() <= take {
+		return "", nil
+	}
+	rows.Set(rows.Slice(0, take))
+
+	last := rows.Index(take - 1)
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		field, _ := keysetOrder(key)
+		v, ok := rowValue(last, field)
+		if !ok {
+			return "", fmt.Errorf("key %s is not found on destination", field)
+		}
+		values[i] = v
+	}
+	return PageToken(values...)
+}
+
+// rowValue returns value of a column from a fetched row, which is either struct or codekit.M
+func rowValue(row reflect.Value, column string) (interface{}, bool) {
+	for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+		if row.IsNil() {
+			return nil, false
+		}
+		row = row.Elem()
+	}
+
+	switch row.Kind() {
+	case reflect.Map:
+		if m, ok := row.Interface().(codekit.M); ok {
+			for k, v := range m {
+				if strings.EqualFold(k, column) {
+					return v, true
+				}
+			}
+		}
+
+	case reflect.Struct:
+		m := codekit.M{}
+		appendStructValues(m, row)
+		for k, v := range m {
+			if strings.EqualFold(k, column) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
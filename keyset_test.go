@@ -0,0 +1,59 @@
+package flexpg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyset(t *testing.T) {
+	cv.Convey("keyset filter", t, func() {
+		q := new(Query)
+		where, err := q.BuildFilter(KeysetAfter([]string{"name", "id"}, "joe", 10))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(where, cv.ShouldEqual, "(name,id) > ('joe',10)")
+
+		where, err = q.BuildFilter(KeysetAfter([]string{"-created", "-id"}, "2024-03-01", 10))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(where, cv.ShouldEqual, "(created,id) < ('2024-03-01',10)")
+
+		where, err = q.BuildFilter(KeysetAfter([]string{"-created", "id"}, "2024-03-01", 10))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(where, cv.ShouldEqual, "((created < '2024-03-01') OR (created = '2024-03-01' AND id > 10))")
+
+		_, err = q.BuildFilter(KeysetAfter([]string{"name", "id"}, "joe"))
+		cv.So(err, cv.ShouldNotBeNil)
+
+		cv.Convey("page token", func() {
+			token, err := PageToken("joe", 12345678901234567)
+			cv.So(err, cv.ShouldBeNil)
+
+			values, err := parsePageToken(token, 2)
+			cv.So(err, cv.ShouldBeNil)
+			where, err = q.BuildFilter(KeysetAfter([]string{"name", "id"}, values...))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, "(name,id) > ('joe','12345678901234567')")
+
+			_, err = parsePageToken(token, 3)
+			cv.So(err, cv.ShouldNotBeNil)
+			_, err = parsePageToken("not a token", 2)
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+
+		cv.Convey("key value of a row", func() {
+			type row struct {
+				ID   int
+				Name string `json:"fullname"`
+			}
+			v, ok := rowValue(reflect.ValueOf(row{ID: 3, Name: "joe"}), "fullname")
+			cv.So(ok, cv.ShouldBeTrue)
+			cv.So(v, cv.ShouldEqual, "joe")
+
+			v, ok = rowValue(reflect.ValueOf(codekit.M{"ID": 3}), "id")
+			cv.So(ok, cv.ShouldBeTrue)
+			cv.So(v, cv.ShouldEqual, 3)
+		})
+	})
+}
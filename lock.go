@@ -0,0 +1,95 @@
+package flexpg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sebarcode/codekit"
+)
+
+// ConfigKeyLock is key of Cursor input to lock selected rows, its value is RowLock,
+// ie: conn.Cursor(cmd, codekit.M{flexpg.ConfigKeyLock: flexpg.ForUpdate().SkipLocked()})
+const ConfigKeyLock = "lock"
+
+// RowLock is locking clause of select command. Rows are locked until the transaction ends, so it should be used
+// inside a transaction
+type RowLock struct {
+	Strength string
+	Tables   []string
+	Wait     string
+}
+
+// ForUpdate locks selected rows for update
+func ForUpdate() RowLock {
+	return RowLock{Strength: "UPDATE"}
+}
+
+// ForNoKeyUpdate locks selected rows for update which doesn't change their key
+func ForNoKeyUpdate() RowLock {
+	return RowLock{Strength: "NO KEY UPDATE"}
+}
+
+// ForShare locks selected rows from being updated or deleted by other transactions
+func ForShare() RowLock {
+	return RowLock{Strength: "SHARE"}
+}
+
+// ForKeyShare locks selected rows from having their key updated or being deleted by other transactions
+func ForKeyShare() RowLock {
+	return RowLock{Strength: "KEY SHARE"}
+}
+
+// Of limits the lock to rows of given tables
+func (l RowLock) Of(tables ...string) RowLock {
+	l.Tables = tables
+	return l
+}
+
+// SkipLocked skips rows locked by other transactions instead of waiting for them
+func (l RowLock) SkipLocked() RowLock {
+	l.Wait = "SKIP LOCKED"
+	return l
+}
+
+// NoWait returns error when a row is locked by other transaction instead of waiting for it
+func (l RowLock) NoWait() RowLock {
+	l.Wait = "NOWAIT"
+	return l
+}
+
+// Clause returns locking clause, ie: FOR UPDATE SKIP LOCKED
+func (l RowLock) Clause() string {
+	parts := []string{"FOR", l.Strength}
+	if len(l.Tables) > 0 {
+		parts = append(parts, "OF", strings.Join(l.Tables, ", "))
+	}
+	if l.Wait != "" {
+		parts = append(parts, l.Wait)
+	}
+	return strings.Join(parts, " ")
+}
+
+// setRowLock adds locking clause of the cursor input into select command
+func (q *Query) setRowLock(in codekit.M, cmdtxt string) (string, error) {
+	v, ok := in[ConfigKeyLock]
+	if !ok || v == nil {
+		return cmdtxt, nil
+	}
+	lock, ok := v.(RowLock)
+	if !ok {
+		return cmdtxt, fmt.Errorf("lock should be flexpg.RowLock, got %T", v)
+	}
+	switch lock.Strength {
+	case "UPDATE", "NO KEY UPDATE", "SHARE", "KEY SHARE":
+	default:
+		return cmdtxt, fmt.Errorf("invalid lock strength: %s", lock.Strength)
+	}
+	if !q.conn.IsTx() {
+		return cmdtxt, errors.New("row lock should be used inside a transaction, call BeginTx first")
+	}
+	if in.GetString(ConfigKeyCount) == CountWindow {
+		return cmdtxt, errors.New("row lock can't be used with window count")
+	}
+	return strings.TrimRight(strings.TrimSpace(cmdtxt), ";") + " " + lock.Clause(), nil
+}
@@ -0,0 +1,41 @@
+package flexpg
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRowLock(t *testing.T) {
+	cv.Convey("locking clause", t, func() {
+		cv.So(ForUpdate().Clause(), cv.ShouldEqual, "FOR UPDATE")
+		cv.So(ForUpdate().SkipLocked().Clause(), cv.ShouldEqual, "FOR UPDATE SKIP LOCKED")
+		cv.So(ForShare().NoWait().Clause(), cv.ShouldEqual, "FOR SHARE NOWAIT")
+		cv.So(ForNoKeyUpdate().Of("jobs").SkipLocked().Clause(), cv.ShouldEqual, "FOR NO KEY UPDATE OF jobs SKIP LOCKED")
+
+		cv.Convey("adding lock into command", func() {
+			q := new(Query)
+			q.conn = new(Connection)
+			cmd := "SELECT * FROM jobs ORDER BY id LIMIT 10"
+
+			res, err := q.setRowLock(nil, cmd)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(res, cv.ShouldEqual, cmd)
+
+			_, err = q.setRowLock(codekit.M{ConfigKeyLock: ForUpdate()}, cmd)
+			cv.So(err, cv.ShouldNotBeNil)
+
+			q.conn.tx = new(sql.Tx)
+			res, err = q.setRowLock(codekit.M{ConfigKeyLock: ForUpdate().SkipLocked()}, cmd+";")
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(res, cv.ShouldEqual, cmd+" FOR UPDATE SKIP LOCKED")
+
+			_, err = q.setRowLock(codekit.M{ConfigKeyLock: "FOR UPDATE"}, cmd)
+			cv.So(err, cv.ShouldNotBeNil)
+			_, err = q.setRowLock(codekit.M{ConfigKeyLock: ForUpdate(), ConfigKeyCount: CountWindow}, cmd)
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package flexpg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/lib/pq"
+)
+
+// Notification is a notification received from a channel
+type Notification struct {
+	Channel string
+	Payload string
+	// PID is process id of the notifying session
+	PID int
+	// Reconnected is true on notification sent after the lost connection is restored, it has no channel nor payload.
+	// Notifications sent while disconnected are lost, so consumer should resync its state on it
+	Reconnected bool
+}
+
+// Subscription receives notifications of the channels it listens to. pq.Listener behind it reconnects when the
+// connection is lost and listens to the channels again
+type Subscription struct {
+	listener *pq.Listener
+	c        chan Notification
+	done     chan struct{}
+	once     sync.Once
+
+	mu       sync.Mutex
+	channels []string
+}
+
+// Listen subscribes to notifications of a channel, more channels could be added by Subscription.Listen.
+// The subscription uses its own connection and should be closed when it is no longer used
+func (c *Connection) Listen(channel string) (*Subscription, error) {
+	s := &Subscription{
+		c:    make(chan Notification, 64),
+		done: make(chan struct{}),
+	}
+	s.listener = pq.NewListener(c.connString(), time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			dbflex.Logger().Warningf("listener of %s: %s", strings.Join(s.channelNames(), ","), err.Error())
+		}
+	})
+	if err := s.Listen(channel); err != nil {
+		s.listener.Close()
+		return nil, err
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *Subscription) loop() {
+	defer close(s.c)
+	for {
+		select {
+		case <-s.done:
+			return
+
+		case n, ok := <-s.listener.Notify:
+			if !ok {
+				return
+			}
+			// nil is sent after reconnect, notifications sent while disconnected are lost
+			notification := Notification{Reconnected: true}
+			if n != nil {
+				notification = Notification{Channel: n.Channel, Payload: n.Extra, PID: n.BePid}
+			}
+			select {
+			case s.c <- notification:
+			case <-s.done:
+				return
+			}
+
+		case <-time.After(90 * time.Second):
+			// check the connection is still alive, so lost connection is detected and reconnected
+			go s.listener.Ping()
+		}
+	}
+}
+
+// C returns channel of received notifications, it is closed when the subscription is closed. Notification with
+// Reconnected set is sent after the connection is restored
+func (s *Subscription) C() <-chan Notification {
+	return s.c
+}
+
+// Listen adds a channel to the subscription
+func (s *Subscription) Listen(channel string) error {
+	if err := s.listener.Listen(channel); err != nil {
+		return fmt.Errorf("unable to listen %s. %s", channel, err.Error())
+	}
+	s.mu.Lock()
+	s.channels = append(s.channels, channel)
+	s.mu.Unlock()
+	return nil
+}
+
+// Unlisten removes a channel from the subscription
+func (s *Subscription) Unlisten(channel string) error {
+	if err := s.listener.Unlisten(channel); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for idx, name := range s.channels {
+		if name == channel {
+			s.channels = append(s.channels[:idx], s.channels[idx+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// channelNames returns channels the subscription listens to
+func (s *Subscription) channelNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.channels...)
+}
+
+// Close stops the subscription and closes its connection
+func (s *Subscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.listener.Close()
+	})
+	return err
+}
+
+// Notify sends notification to a channel. Inside transaction, the notification is delivered when it is committed
+func (c *Connection) Notify(channel string, payload string) error {
+	cmdtxt := fmt.Sprintf("SELECT pg_notify('%s', '%s')", CleanupSQL(channel), CleanupSQL(payload))
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	var err error
+	if c.IsTx() {
+		_, err = c.tx.Exec(cmdtxt)
+	} else {
+		_, err = c.db.Exec(cmdtxt)
+	}
+	if err != nil {
+		return fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+	return nil
+}
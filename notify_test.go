@@ -0,0 +1,38 @@
+package flexpg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSubscriptionLoop(t *testing.T) {
+	cv.Convey("receiving notifications", t, func() {
+		s := &Subscription{
+			listener: &pq.Listener{Notify: make(chan *pq.Notification)},
+			c:        make(chan Notification, 64),
+			done:     make(chan struct{}),
+			channels: []string{"jobs", "mails"},
+		}
+		go s.loop()
+		defer close(s.done)
+
+		s.listener.Notify <- &pq.Notification{Channel: "jobs", Extra: "mail", BePid: 10}
+		s.listener.Notify <- nil
+
+		for _, expected := range []Notification{
+			{Channel: "jobs", Payload: "mail", PID: 10},
+			{Reconnected: true},
+		} {
+			select {
+			case n := <-s.C():
+				cv.So(n, cv.ShouldResemble, expected)
+			case <-time.After(time.Second):
+				t.Fatal("notification is not received")
+			}
+		}
+		cv.So(s.channelNames(), cv.ShouldResemble, []string{"jobs", "mails"})
+	})
+}
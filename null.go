@@ -0,0 +1,80 @@
+package flexpg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// isNullValue returns true if value is written as SQL NULL: nil, nil pointer, map or slice and invalid sql.Null*.
+// driver.Valuer which value is nil is handled by valuerSQLValue, so its Value is called once
+func isNullValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if rv.IsNil() {
+			return true
+		}
+	}
+	if _, isSQLNull := sqlNullValueType(rv.Type()); isSQLNull {
+		return !rv.FieldByName("Valid").Bool()
+	}
+	return false
+}
+
+// indirectValue dereferences pointer and unwraps value of valid sql.Null*, so it is written as its value
+func indirectValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Invalid {
+		return v
+	}
+	if _, isSQLNull := sqlNullValueType(rv.Type()); isSQLNull {
+		return rv.Field(0).Interface()
+	}
+	return rv.Interface()
+}
+
+// castNull returns value of NULL column for a type: nil for pointer, map, slice and interface, invalid sql.Null*
+// and zero value for others
+func castNull(refType reflect.Type) interface{} {
+	if refType == nil {
+		return nil
+	}
+	switch refType.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return nil
+	}
+	return reflect.Zero(refType).Interface()
+}
+
+// castPtr converts value into type of pointer element, then returns pointer of it
+func castPtr(value interface{}, refType reflect.Type) (interface{}, error) {
+	v, err := processByTypeName(value, refType.Elem(), refType.Elem().String())
+	if err != nil {
+		return nil, err
+	}
+	rv, err := convertTo(v, value, refType.Elem())
+	if err != nil {
+		return nil, err
+	}
+	ptr := reflect.New(refType.Elem())
+	ptr.Elem().Set(rv)
+	return ptr.Interface(), nil
+}
+
+// convertTo converts casted value into t, value returned by database is used when it could not be casted
+func convertTo(casted interface{}, value interface{}, t reflect.Type) (reflect.Value, error) {
+	if casted == nil {
+		casted = value
+	}
+	rv := reflect.ValueOf(casted)
+	if !rv.Type().ConvertibleTo(t) || (rv.Kind() != reflect.String && t.Kind() == reflect.String) {
+		return rv, fmt.Errorf("unable to cast %s into %s", rv.Type().String(), t.String())
+	}
+	return rv.Convert(t), nil
+}
@@ -17,12 +17,15 @@ type Query struct {
 	rdbms.Query
 	conn       *Connection
 	sqlcommand string
+	valueErr   error
 }
 
 // Cursor produces a cursor from query
 func (q *Query) Cursor(in codekit.M) dbflex.ICursor {
 	cursor := new(Cursor)
 	cursor.SetThis(cursor)
+	cursor.location = q.conn.location
+	cursor.conn = q.conn
 
 	ct := q.Config(dbflex.ConfigKeyCommandType, dbflex.QuerySelect).(string)
 	if ct != dbflex.QuerySelect && ct != dbflex.QuerySQL && ct != dbflex.QueryCommand {
@@ -35,29 +38,57 @@ func (q *Query) Cursor(in codekit.M) dbflex.ICursor {
 		cursor.SetError(fmt.Errorf("no command"))
 		return cursor
 	}
+	if q.valueErr != nil {
+		cursor.SetError(fmt.Errorf("unable to write value. %s", q.valueErr.Error()))
+		return cursor
+	}
 
 	tablename := q.Config(dbflex.ConfigKeyTableName, "").(string)
-	cq := dbflex.From(tablename).Select("count(*) as Count")
-	if filter := q.Config(dbflex.ConfigKeyFilter, nil); filter != nil {
-		cq.Where(filter.(*dbflex.Filter))
+	if ct == dbflex.QuerySelect && tablename != "" {
+		cq := dbflex.From(tablename).Select("count(*) as Count")
+		if filter := q.Config(dbflex.ConfigKeyFilter, nil); filter != nil {
+			cq.Where(filter.(*dbflex.Filter))
+		}
+		cursor.SetCountCommand(cq)
+	} else {
+		// raw sql and command are counted as subquery
+		cursor.SetCountCommand(dbflex.SQL(sqlCountCommand(cmdtxt)))
 	}
-	cursor.SetCountCommand(cq)
 
-	var (
-		rows *sql.Rows
-		err  error
-	)
+	var err error
+	if cmdtxt, err = q.setCountMode(cursor, in, cmdtxt); err != nil {
+		cursor.SetError(err)
+		return cursor
+	}
+	if cmdtxt, err = q.setRowLock(in, cmdtxt); err != nil {
+		cursor.SetError(err)
+		return cursor
+	}
 
-	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
-	if q.conn.IsTx() {
-		rows, err = q.conn.tx.Query(cmdtxt)
+	var rows *sql.Rows
+	if batchSize, ok := streamBatchSize(in); ok {
+		if cursor.stream, err = q.conn.declareCursor(cmdtxt, batchSize); err != nil {
+			cursor.SetError(err)
+			return cursor
+		}
+		rows, err = cursor.stream.fetch()
 	} else {
-		rows, err = q.conn.db.Query(cmdtxt)
+		dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+		if q.conn.IsTx() {
+			rows, err = q.conn.tx.Query(cmdtxt)
+		} else {
+			rows, err = q.conn.db.Query(cmdtxt)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s. SQL Command: %s", err.Error(), cmdtxt)
+		}
 	}
 	if rows == nil {
-		cursor.SetError(fmt.Errorf("%s. SQL Command: %s", err.Error(), cmdtxt))
+		cursor.SetError(err)
 	} else {
-		cursor.SetFetcher(rows)
+		if err = cursor.SetFetcher(rows); err != nil {
+			cursor.SetError(err)
+		}
 	}
 	return cursor
 }
@@ -76,6 +107,8 @@ func (q *Query) Execute(in codekit.M) (interface{}, error) {
 	var (
 		sqlfieldnames []string
 		sqlvalues     []string
+		version       *rowVersion
+		err           error
 	)
 
 	data, hasData := in["data"]
@@ -84,7 +117,11 @@ func (q *Query) Execute(in codekit.M) (interface{}, error) {
 	}
 
 	if hasData {
-		sqlfieldnames, _, _, sqlvalues = rdbms.ParseSQLMetadata(q, data)
+		q.valueErr = nil
+		sqlfieldnames, _, _, sqlvalues = rdbms.ParseSQLMetadata(q, flattenStruct(data))
+		if q.valueErr != nil {
+			return nil, fmt.Errorf("unable to write value. %s", q.valueErr.Error())
+		}
 		affectedfields := q.Config("fields", []string{}).([]string)
 		if len(affectedfields) > 0 {
 			newfieldnames := []string{}
@@ -100,6 +137,34 @@ func (q *Query) Execute(in codekit.M) (interface{}, error) {
 			sqlfieldnames = newfieldnames
 			sqlvalues = newvalues
 		}
+
+		// generated and identity always columns are maintained by database
+		if readOnlyFields := readOnlyColumns(data); len(readOnlyFields) > 0 {
+			newfieldnames := []string{}
+			newvalues := []string{}
+			for idx, field := range sqlfieldnames {
+				if !codekit.HasMember(readOnlyFields, strings.ToLower(field)) {
+					newfieldnames = append(newfieldnames, field)
+					newvalues = append(newvalues, sqlvalues[idx])
+				}
+			}
+			sqlfieldnames = newfieldnames
+			sqlvalues = newvalues
+		}
+
+		if cmdtype == dbflex.QueryInsert || cmdtype == dbflex.QueryUpdate {
+			if version, err = newRowVersion(data); err != nil {
+				return nil, err
+			}
+		}
+		if version != nil {
+			sqlfieldnames, sqlvalues = version.apply(cmdtype, sqlfieldnames, sqlvalues)
+			if cmdtype == dbflex.QueryUpdate {
+				if cmdtxt, err = q.updateCommand(version); err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
 
 	switch cmdtype {
@@ -118,11 +183,7 @@ func (q *Query) Execute(in codekit.M) (interface{}, error) {
 	}
 
 	//fmt.Println("Cmd: ", cmdtxt)
-	var (
-		r   sql.Result
-		err error
-	)
-
+	var r sql.Result
 	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
 	if q.conn.IsTx() {
 		r, err = q.conn.tx.Exec(cmdtxt)
@@ -133,6 +194,15 @@ func (q *Query) Execute(in codekit.M) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%s. SQL Command: %s", err.Error(), cmdtxt)
 	}
+
+	if version != nil {
+		if cmdtype == dbflex.QueryUpdate {
+			if affected, err := r.RowsAffected(); err == nil && affected == 0 {
+				return r, ErrVersionConflict
+			}
+		}
+		version.written(cmdtype)
+	}
 	return r, nil
 }
 
@@ -155,19 +225,32 @@ func CleanupSQL(s string) string {
 	return strings.Replace(s, "'", "''", -1)
 }
 
+// tsValue writes time with microsecond precision and its offset, so it is stored as the same instant
 func tsValue(dt time.Time) string {
-	if dt.IsZero() {
-		return codekit.Date2String(dt, "'yyyy-MM-dd HH:mm:ss TH'")
-	}
-	return codekit.Date2String(dt, "'yyyy-MM-dd HH:mm:ss TH'")
+	return "'" + dt.Round(time.Microsecond).Format(timestampLayout) + "'"
 }
 
 func (qr *Query) ValueToSQlValue(v interface{}) string {
+	if isNullValue(v) {
+		return "NULL"
+	}
+	v = indirectValue(v)
+	if sqlValue, ok := registeredSQLValue(v); ok {
+		return sqlValue
+	}
+	if sqlValue, ok := qr.valuerSQLValue(v); ok {
+		return sqlValue
+	}
+
 	switch v.(type) {
-	case int, int8, int16, int32, int64:
+	case ArrayValue:
+		return v.(ArrayValue).SQLValue()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		return fmt.Sprintf("%d", v)
-	case float32, float64:
-		return fmt.Sprintf("%f", v)
+	case float32:
+		return floatSQLValue(float64(v.(float32)), 32)
+	case float64:
+		return floatSQLValue(v.(float64), 64)
 	case bool:
 		vbool, ok := v.(bool)
 		if ok {
@@ -180,16 +263,12 @@ func (qr *Query) ValueToSQlValue(v interface{}) string {
 		return "false"
 	case time.Time:
 		return tsValue(v.(time.Time)) + "::timestamptz"
-	case *time.Time:
-		dt := v.(*time.Time)
-		if dt == nil {
-			dtZero := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-			return tsValue(dtZero) + "::timestamptz"
-		}
-		return tsValue(*dt) + "::timestamptz"
 	case string:
 		return fmt.Sprintf("'%s'", CleanupSQL(v.(string)))
 	default:
+		if sqlValue, ok := kindSQLValue(v); ok {
+			return sqlValue
+		}
 		return fmt.Sprintf("'%s'", CleanupSQL(fmt.Sprintf("%v", codekit.JsonString(v))))
 	}
 }
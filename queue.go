@@ -0,0 +1,304 @@
+package flexpg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/sebarcode/codekit"
+)
+
+// Status of a job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is a row of queue table
+type Job struct {
+	ID          int64           `db_identity:"by default"`
+	Queue       string          `db_type:"varchar(64)"`
+	Payload     json.RawMessage `db_null:"true"`
+	Priority    int             `db_default:"0"`
+	Status      string          `db_type:"varchar(16)" db_check:"status in ('queued','running','done','dead')"`
+	RunAt       time.Time       `db_default:"now()"`
+	Attempts    int             `db_default:"0"`
+	MaxAttempts int             `db_default:"5"`
+	LockedUntil *time.Time
+	LastError   string    `db_default:"''"`
+	Created     time.Time `db_default:"now()"`
+	Updated     time.Time `db_default:"now()"`
+}
+
+// UnmarshalPayload decodes payload of the job into v
+func (j *Job) UnmarshalPayload(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// EnqueueOptions are optional settings of a new job
+type EnqueueOptions struct {
+	// Priority of the job, higher is claimed first
+	Priority int
+	// RunAt is time the job could be claimed, it is now when zero
+	RunAt time.Time
+	// MaxAttempts is number of attempts before the job is dead, queue MaxAttempts is used when zero
+	MaxAttempts int
+}
+
+// Queue is job queue stored on a table. Jobs are claimed by workers using FOR UPDATE SKIP LOCKED, a claimed job is
+// invisible to other workers until VisibilityTimeout passes, so job of a crashed worker is claimed again
+type Queue struct {
+	conn  *Connection
+	table string
+
+	// VisibilityTimeout is how long a claimed job is locked for its worker
+	VisibilityTimeout time.Duration
+	// MaxAttempts is default number of attempts before a job is dead
+	MaxAttempts int
+	// Backoff returns delay before a failed job is retried, attempt starts from 1
+	Backoff func(attempt int) time.Duration
+	// Notify sends NOTIFY on enqueue, so workers are woken up without waiting for the poll interval
+	Notify bool
+}
+
+// NewQueue returns queue stored on a table, call Ensure to create the table
+func NewQueue(conn *Connection, table string) *Queue {
+	return &Queue{
+		conn:              conn,
+		table:             table,
+		VisibilityTimeout: 5 * time.Minute,
+		MaxAttempts:       5,
+		Backoff:           ExponentialBackoff(time.Second, time.Hour),
+	}
+}
+
+// ExponentialBackoff returns backoff doubling its delay on each attempt, starting from base and limited by max
+func ExponentialBackoff(base, max time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// Channel returns name of the channel notified on enqueue
+func (q *Queue) Channel() string {
+	return tableBaseName(q.table) + "_enqueued"
+}
+
+// Ensure creates or updates the queue table and its index
+func (q *Queue) Ensure() error {
+	if err := q.conn.EnsureTable(q.table, []string{"ID"}, new(Job)); err != nil {
+		return err
+	}
+	return q.conn.EnsureIndex(q.table, "claim", false, "queue", "status", "runat")
+}
+
+// Enqueue adds a job into a queue, payload is marshalled as json. It returns id of the job
+func (q *Queue) Enqueue(queue string, payload interface{}, opts *EnqueueOptions) (int64, error) {
+	if opts == nil {
+		opts = &EnqueueOptions{}
+	}
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("unable to marshal payload. %s", err.Error())
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.MaxAttempts
+	}
+	runAt := "now()"
+	if !opts.RunAt.IsZero() {
+		runAt = tsValue(opts.RunAt) + "::timestamptz"
+	}
+
+	cmdtxt := fmt.Sprintf("INSERT INTO %s (queue,payload,priority,status,runat,maxattempts) VALUES ('%s',%s,%d,'%s',%s,%d) RETURNING id",
+		q.table, CleanupSQL(queue), rawJSONToSQL(json.RawMessage(bs)), opts.Priority, JobQueued, runAt, maxAttempts)
+	var id int64
+	if err = q.conn.queryRow(cmdtxt).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+
+	if q.Notify {
+		if err = q.conn.Notify(q.Channel(), queue); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// Claim locks up to n jobs of a queue which are due, the highest priority first. Job of which visibility timeout has
+// passed is claimed again, or marked as dead when it has no attempt left. Due jobs are selected using ConfigKeyLock
+// with FOR UPDATE SKIP LOCKED inside a transaction of its own, so workers sharing the connection never claim the same
+// job. Inside BeginTx, the transaction of the connection is used instead
+func (q *Queue) Claim(queue string, n int) ([]Job, error) {
+	return q.claim(context.Background(), queue, n)
+}
+
+func (q *Queue) claim(ctx context.Context, queue string, n int) (jobs []Job, err error) {
+	if n <= 0 {
+		n = 1
+	}
+
+	conn := q.conn
+	if !conn.IsTx() {
+		var tx *sql.Tx
+		if tx, err = q.conn.db.BeginTx(ctx, nil); err != nil {
+			return nil, err
+		}
+		conn = q.conn.txConnection(tx)
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+				return
+			}
+			err = tx.Commit()
+		}()
+	}
+
+	dead := fmt.Sprintf("UPDATE %s SET status='%s', lockeduntil=NULL, lasterror='visibility timeout', updated=now() "+
+		"WHERE queue='%s' AND status='%s' AND lockeduntil < now() AND attempts >= maxattempts",
+		q.table, JobDead, CleanupSQL(queue), JobRunning)
+	dbflex.Logger().Debugf("execute command: %s", dead)
+	if _, err = conn.tx.Exec(dead); err != nil {
+		return nil, fmt.Errorf("error: %s command: %s", err.Error(), dead)
+	}
+
+	due := fmt.Sprintf("SELECT id FROM %s WHERE queue='%s' AND attempts < maxattempts AND "+
+		"((status='%s' AND runat <= now()) OR (status='%s' AND lockeduntil < now())) "+
+		"ORDER BY priority DESC, runat, id LIMIT %d", q.table, CleanupSQL(queue), JobQueued, JobRunning, n)
+	rows := []struct{ ID int64 }{}
+	if err = conn.Cursor(dbflex.SQL(due), codekit.M{ConfigKeyLock: ForUpdate().SkipLocked()}).Fetchs(&rows, 0).Close(); err != nil {
+		return nil, err
+	}
+	jobs = []Job{}
+	if len(rows) == 0 {
+		return jobs, nil
+	}
+	ids := make([]string, len(rows))
+	for idx, row := range rows {
+		ids[idx] = fmt.Sprintf("%d", row.ID)
+	}
+
+	claim := fmt.Sprintf("UPDATE %s SET status='%s', attempts=attempts+1, lockeduntil=now()+%s, updated=now() "+
+		"WHERE id IN (%s) RETURNING *", q.table, JobRunning, durationToSQL(q.VisibilityTimeout), strings.Join(ids, ","))
+	if err = conn.Cursor(dbflex.SQL(claim), nil).Fetchs(&jobs, 0).Close(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobOrder(jobs[i], jobs[j]) })
+	return jobs, nil
+}
+
+// jobOrder returns true if job a is claimed before job b
+func jobOrder(a, b Job) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if !a.RunAt.Equal(b.RunAt) {
+		return a.RunAt.Before(b.RunAt)
+	}
+	return a.ID < b.ID
+}
+
+// Complete marks a claimed job as done
+func (q *Queue) Complete(job *Job) error {
+	return q.finish(job, fmt.Sprintf("status='%s', lockeduntil=NULL", JobDone))
+}
+
+// Fail records error of a claimed job, the job is retried after its backoff or marked as dead when it has no attempt left
+func (q *Queue) Fail(job *Job, jobErr error) error {
+	msg := ""
+	if jobErr != nil {
+		msg = jobErr.Error()
+	}
+	if job.Attempts >= job.MaxAttempts {
+		return q.finish(job, fmt.Sprintf("status='%s', lockeduntil=NULL, lasterror='%s'", JobDead, CleanupSQL(msg)))
+	}
+	delay := q.Backoff(job.Attempts)
+	return q.finish(job, fmt.Sprintf("status='%s', lockeduntil=NULL, lasterror='%s', runat=now()+%s",
+		JobQueued, CleanupSQL(msg), durationToSQL(delay)))
+}
+
+// finish updates a claimed job, the update is skipped when the job has been claimed again by other worker
+func (q *Queue) finish(job *Job, set string) error {
+	return q.exec(fmt.Sprintf("UPDATE %s SET %s, updated=now() WHERE id=%d AND status='%s' AND attempts=%d",
+		q.table, set, job.ID, JobRunning, job.Attempts))
+}
+
+func (q *Queue) exec(cmdtxt string) error {
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	var err error
+	if q.conn.IsTx() {
+		_, err = q.conn.tx.Exec(cmdtxt)
+	} else {
+		_, err = q.conn.db.Exec(cmdtxt)
+	}
+	if err != nil {
+		return fmt.Errorf("error: %s command: %s", err.Error(), cmdtxt)
+	}
+	return nil
+}
+
+// Work claims and handles jobs of a queue one by one until ctx is done. Job is completed when handler returns nil,
+// otherwise it is failed. When there is no due job, worker waits for pollInterval or for notification if Notify is set
+func (q *Queue) Work(ctx context.Context, queue string, pollInterval time.Duration, handler func(*Job) error) error {
+	if q.conn.IsTx() {
+		return errors.New("worker should not run inside a transaction")
+	}
+
+	var wakeup <-chan Notification
+	if q.Notify {
+		subscription, err := q.conn.Listen(q.Channel())
+		if err != nil {
+			return err
+		}
+		defer subscription.Close()
+		wakeup = subscription.C()
+	}
+
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		jobs, err := q.claim(ctx, queue, 1)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 1 {
+			job := &jobs[0]
+			if jobErr := handler(job); jobErr != nil {
+				err = q.Fail(job, jobErr)
+			} else {
+				err = q.Complete(job)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wakeup:
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package flexpg
+
+import (
+	"testing"
+	"time"
+
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueueBackoff(t *testing.T) {
+	cv.Convey("exponential backoff", t, func() {
+		backoff := ExponentialBackoff(time.Second, 10*time.Second)
+		cv.So(backoff(1), cv.ShouldEqual, time.Second)
+		cv.So(backoff(2), cv.ShouldEqual, 2*time.Second)
+		cv.So(backoff(4), cv.ShouldEqual, 8*time.Second)
+		cv.So(backoff(10), cv.ShouldEqual, 10*time.Second)
+
+		cv.Convey("queue table", func() {
+			q := NewQueue(new(Connection), "app.jobs")
+			cv.So(q.Channel(), cv.ShouldEqual, "jobs_enqueued")
+
+			cols, err := tableColumns(new(Job))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(cols[0].Definition(), cv.ShouldEqual, "id bigint GENERATED BY DEFAULT AS IDENTITY")
+			cv.So(cols[2].Definition(), cv.ShouldEqual, "payload jsonb")
+		})
+	})
+}
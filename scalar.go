@@ -0,0 +1,216 @@
+package flexpg
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CastError is returned when a value returned by database can't be converted into type of its target
+type CastError struct {
+	Column string
+	Value  interface{}
+	Type   reflect.Type
+	Err    error
+}
+
+func (e *CastError) Error() string {
+	column := ""
+	if e.Column != "" {
+		column = fmt.Sprintf(" of column %s", e.Column)
+	}
+	msg := fmt.Sprintf("unable to cast %T %v%s into %s", e.Value, castErrorValue(e.Value), column, e.Type)
+	if e.Err != nil {
+		msg += ". " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *CastError) Unwrap() error {
+	return e.Err
+}
+
+func castErrorValue(v interface{}) string {
+	if bs, ok := v.([]byte); ok {
+		v = string(bs)
+	}
+	str := fmt.Sprintf("%q", fmt.Sprintf("%v", v))
+	if len(str) > 64 {
+		str = str[:61] + "..."
+	}
+	return str
+}
+
+// isScalarType returns true if castScalar converts value into the type
+func isScalarType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8 && t.Len() == 16
+	}
+	return false
+}
+
+// castScalar converts value returned by database driver, which is one of int64, float64, bool, []byte, string or
+// time.Time, into a scalar type including named type such as type Level int
+func castScalar(value interface{}, t reflect.Type) (interface{}, error) {
+	res := reflect.New(t).Elem()
+	if err := setScalar(res, value); err != nil {
+		return nil, &CastError{Value: value, Type: t, Err: err}
+	}
+	return res.Interface(), nil
+}
+
+func setScalar(target reflect.Value, value interface{}) error {
+	text, isText := scalarText(value)
+	rv := reflect.ValueOf(value)
+
+	switch target.Kind() {
+	case reflect.Bool:
+		switch {
+		case rv.Kind() == reflect.Bool:
+			target.SetBool(rv.Bool())
+		case isText:
+			b, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				return err
+			}
+			target.SetBool(b)
+		default:
+			return fmt.Errorf("unsupported source type")
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case rv.Kind() == reflect.Int64:
+			i = rv.Int()
+		case rv.Kind() == reflect.Float64 && rv.Float() == math.Trunc(rv.Float()):
+			i = int64(rv.Float())
+		case isText:
+			var err error
+			if i, err = strconv.ParseInt(strings.TrimSpace(text), 10, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported source type")
+		}
+		if target.OverflowInt(i) {
+			return fmt.Errorf("value overflows")
+		}
+		target.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch {
+		case rv.Kind() == reflect.Int64 && rv.Int() >= 0:
+			u = uint64(rv.Int())
+		case rv.Kind() == reflect.Float64 && rv.Float() >= 0 && rv.Float() == math.Trunc(rv.Float()):
+			u = uint64(rv.Float())
+		case isText:
+			var err error
+			if u, err = strconv.ParseUint(strings.TrimSpace(text), 10, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported source type")
+		}
+		if target.OverflowUint(u) {
+			return fmt.Errorf("value overflows")
+		}
+		target.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch {
+		case rv.Kind() == reflect.Float64:
+			f = rv.Float()
+		case rv.Kind() == reflect.Int64:
+			f = float64(rv.Int())
+		case isText:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(text), 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported source type")
+		}
+		if target.Kind() == reflect.Float32 && target.OverflowFloat(f) {
+			return fmt.Errorf("value overflows")
+		}
+		target.SetFloat(f)
+
+	case reflect.String:
+		switch v := value.(type) {
+		case int64:
+			target.SetString(strconv.FormatInt(v, 10))
+		case float64:
+			target.SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			target.SetString(strconv.FormatBool(v))
+		case time.Time:
+			target.SetString(v.Format(timestampLayout))
+		default:
+			if !isText {
+				return fmt.Errorf("unsupported source type")
+			}
+			target.SetString(text)
+		}
+
+	case reflect.Slice:
+		if !isText {
+			return fmt.Errorf("unsupported source type")
+		}
+		bs := []byte(text)
+		// bytea in hex output format, ie: \x6162. Driver returns bytea as decoded []byte, so only string is decoded
+		if _, isString := value.(string); isString && strings.HasPrefix(text, `\x`) {
+			decoded, err := hex.DecodeString(text[2:])
+			if err != nil {
+				return err
+			}
+			bs = decoded
+		}
+		target.SetBytes(bs)
+
+	case reflect.Array:
+		if !isText {
+			return fmt.Errorf("unsupported source type")
+		}
+		bs := []byte(text)
+		if len(bs) != target.Len() {
+			// uuid text, ie: a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11
+			decoded, err := hex.DecodeString(strings.ReplaceAll(strings.Trim(text, "{}"), "-", ""))
+			if err != nil {
+				return err
+			}
+			bs = decoded
+		}
+		if len(bs) != target.Len() {
+			return fmt.Errorf("expecting %d bytes, got %d", target.Len(), len(bs))
+		}
+		reflect.Copy(target, reflect.ValueOf(bs))
+
+	default:
+		return fmt.Errorf("unsupported target type")
+	}
+	return nil
+}
+
+func scalarText(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	}
+	return "", false
+}
@@ -0,0 +1,352 @@
+package flexpg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sebarcode/codekit"
+)
+
+// columnDef is column definition of a table, derived from a struct field and its tags, see EnsureTable
+type columnDef struct {
+	Name        string
+	FieldName   string
+	Type        string
+	Default     string
+	NotNull     bool
+	UniqueGroup string
+	Check       string
+	Comment     string
+	Collate     string
+	Identity    string
+	Generated   string
+	ForeignKey  *ForeignKey
+	Enum        *enumType
+	Version     bool
+	// Index is index path of the field in the struct, embedded structs included, see reflect.Value.FieldByIndex
+	Index []int
+	// FloatNumeric is set when float type is derived from Go type, existing numeric column of the field is kept
+	// since earlier versions mapped float32 and float64 to numeric
+	FloatNumeric bool
+}
+
+// tableColumns returns column definitions of a struct
+func tableColumns(obj interface{}) ([]columnDef, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("object should be a struct")
+	}
+
+	return appendStructColumns([]columnDef{}, v.Type(), nil), nil
+}
+
+func appendStructColumns(cols []columnDef, t reflect.Type, prefix []int) []columnDef {
+	fnum := t.NumField()
+	for i := 0; i < fnum; i++ {
+		f := t.Field(i)
+		fieldName := f.Name
+		alias := fieldAlias(f)
+		if alias == "-" {
+			continue
+		}
+		index := append(append([]int{}, prefix...), i)
+		if isFlattenedField(f) {
+			cols = appendStructColumns(cols, indirectType(f.Type), index)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if alias != "" {
+			fieldName = alias
+		}
+		col := newColumnDef(fieldName, f)
+		col.Index = index
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func fieldAlias(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get(codekit.TagName()), ",")[0]
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// isFlattenedField returns true if fields of an embedded struct are stored as columns of the table, the same way
+// encoding/json promotes them. Use db_json:"true" tag to store the embedded struct as jsonb column instead
+func isFlattenedField(f reflect.StructField) bool {
+	if !f.Anonymous || fieldAlias(f) != "" || f.Tag.Get("db_json") == "true" {
+		return false
+	}
+	t := indirectType(f.Type)
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	_, isSQLNull := sqlNullValueType(t)
+	return !isSQLNull
+}
+
+// flattenStruct converts struct which has flattened embedded struct or native array field into codekit.M keyed
+// by column name, so its promoted fields are written as columns and arrays are not written as jsonb.
+// Other values are returned as is
+func flattenStruct(obj interface{}) interface{} {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return obj
+	}
+
+	t := v.Type()
+	needConversion := false
+	for i := 0; i < t.NumField(); i++ {
+		if isFlattenedField(t.Field(i)) || isArrayField(t.Field(i)) {
+			needConversion = true
+			break
+		}
+	}
+	if !needConversion {
+		return obj
+	}
+
+	res := codekit.M{}
+	appendStructValues(res, v)
+	return res
+}
+
+func appendStructValues(m codekit.M, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		alias := fieldAlias(f)
+		if alias == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if isFlattenedField(f) {
+			// fields of nil embedded pointer are left to the database
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+			appendStructValues(m, reflect.Indirect(fv))
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		fieldName := f.Name
+		if alias != "" {
+			fieldName = alias
+		}
+		if isArrayField(f) {
+			m.Set(strings.ToLower(fieldName), Array(fv.Interface()))
+			continue
+		}
+		m.Set(strings.ToLower(fieldName), fv.Interface())
+	}
+}
+
+func newColumnDef(fieldName string, f reflect.StructField) columnDef {
+	tag := f.Tag
+	col := columnDef{
+		Name:      strings.ToLower(fieldName),
+		FieldName: f.Name,
+		Type:      fieldDBType(f),
+		Default:   tag.Get("db_default"),
+		Check:     tag.Get("db_check"),
+		Comment:   tag.Get("db_comment"),
+		Collate:   tag.Get("db_collate"),
+		Generated: tag.Get("db_generated"),
+		NotNull:   !isNullableType(f.Type),
+	}
+	if columns := tag.Get("db_tsvector"); columns != "" {
+		col.Generated = tsVectorExpr(tag.Get("db_tsconfig"), strings.Split(columns, ","))
+		if tag.Get("db_type") == "" {
+			col.Type = "tsvector"
+		}
+	}
+	col.ForeignKey = newColumnForeignKey(col.Name, tag)
+	if tag.Get("db_type") == "" {
+		col.Enum = enumOf(f.Type)
+		col.FloatNumeric = tag.Get("db_precision") == "" && (col.Type == "real" || col.Type == "double precision")
+	}
+
+	if _, ok := tag.Lookup("required"); ok {
+		col.NotNull = true
+	}
+	if null, ok := tag.Lookup("db_null"); ok {
+		col.NotNull = null == "false"
+	}
+	if tag.Get("db_version") == "true" {
+		col.Version = true
+		col.NotNull = true
+		if col.Default == "" {
+			col.Default = "1"
+		}
+	}
+	if unique, ok := tag.Lookup("db_unique"); ok && unique != "false" {
+		col.UniqueGroup = strings.ToLower(unique)
+		if col.UniqueGroup == "" {
+			col.UniqueGroup = "true"
+		}
+	}
+	if identity, ok := tag.Lookup("db_identity"); ok {
+		switch strings.ToLower(identity) {
+		case "", "always", "true":
+			col.Identity = "always"
+		case "default", "by default":
+			col.Identity = "by default"
+		}
+	}
+	return col
+}
+
+// isNullableType returns true if value of the type could be nil, or it is one of sql.Null* types
+func isNullableType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	}
+	_, isSQLNull := sqlNullValueType(t)
+	return isSQLNull
+}
+
+// sqlNullValueType returns type of value wrapped by sql.NullString, sql.NullInt64, sql.Null[T] and so on
+func sqlNullValueType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Struct || t.PkgPath() != "database/sql" || !strings.HasPrefix(t.Name(), "Null") {
+		return t, false
+	}
+	if _, hasValid := t.FieldByName("Valid"); !hasValid || t.NumField() != 2 {
+		return t, false
+	}
+	return t.Field(0).Type, true
+}
+
+// fieldDBType returns database type of a struct field, either from db_type, db_precision tag or from its Go type mapping
+func fieldDBType(f reflect.StructField) string {
+	if dbType := f.Tag.Get("db_type"); dbType != "" {
+		return dbType
+	}
+	if precision := f.Tag.Get("db_precision"); precision != "" {
+		if scale := f.Tag.Get("db_scale"); scale != "" {
+			return fmt.Sprintf("numeric(%s,%s)", precision, scale)
+		}
+		return fmt.Sprintf("numeric(%s)", precision)
+	}
+	if isArrayField(f) {
+		return dbTypeOf(indirectType(f.Type).Elem()) + "[]"
+	}
+
+	return dbTypeOf(f.Type)
+}
+
+// IsReadOnly returns true if column value is produced by database and can't be written
+func (col columnDef) IsReadOnly() bool {
+	return col.Generated != "" || col.Identity == "always"
+}
+
+// Definition returns column definition clause for create table and add column command
+func (col columnDef) Definition() string {
+	parts := []string{col.Name, col.Type}
+	if col.Collate != "" {
+		parts = append(parts, fmt.Sprintf("COLLATE \"%s\"", col.Collate))
+	}
+	if col.Generated != "" {
+		parts = append(parts, fmt.Sprintf("GENERATED ALWAYS AS (%s) STORED", col.Generated))
+	} else if col.Identity != "" {
+		parts = append(parts, fmt.Sprintf("GENERATED %s AS IDENTITY", strings.ToUpper(col.Identity)))
+	} else if col.Default != "" {
+		parts = append(parts, "DEFAULT "+col.Default)
+	}
+	if col.NotNull && col.Identity == "" {
+		parts = append(parts, "NOT NULL")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Constraints returns check constraint clauses of the column
+func (col columnDef) Constraints(tableName string) []string {
+	res := []string{}
+	if col.Check != "" {
+		res = append(res, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", col.CheckName(tableName), col.Check))
+	}
+	return res
+}
+
+// CheckName returns name of check constraint of the column
+func (col columnDef) CheckName(tableName string) string {
+	return constraintName(tableName, col.Name, "check")
+}
+
+// CommentCommand returns command to set comment of the column
+func (col columnDef) CommentCommand(tableName string) string {
+	if col.Comment == "" {
+		return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS NULL", tableName, col.Name)
+	}
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s'", tableName, col.Name, CleanupSQL(col.Comment))
+}
+
+// constraintName follows postgres naming of constraint, ie: orders_code_key. Schema name is excluded
+func constraintName(tableName string, columnName string, suffix string) string {
+	return strings.ToLower(fmt.Sprintf("%s_%s_%s", tableBaseName(tableName), columnName, suffix))
+}
+
+// managedConstraintComment marks constraints created by EnsureTable, only these are dropped when the model no longer
+// declares them, so constraints created by hand are kept
+const managedConstraintComment = "managed by flexpg"
+
+// managedConstraintCommand returns command to mark a constraint as created by EnsureTable
+func managedConstraintCommand(tableName, name string) string {
+	return fmt.Sprintf("COMMENT ON CONSTRAINT %s ON %s IS '%s'", name, tableName, managedConstraintComment)
+}
+
+// tableBaseName returns table name without its schema
+func tableBaseName(tableName string) string {
+	if idx := strings.LastIndex(tableName, "."); idx >= 0 {
+		return tableName[idx+1:]
+	}
+	return tableName
+}
+
+var (
+	sqlCastRegex  = regexp.MustCompile(`::[a-z_]+( [a-z_]+)*(\(\d+(,\s*\d+)?\))?(\[\])?`)
+	sqlSpaceRegex = regexp.MustCompile(`[\s()]+`)
+)
+
+// sameSQLExpr loosely compares two SQL expressions, ignoring casts, parentheses and spaces that postgres adds when storing
+// default value and check constraint
+func sameSQLExpr(a, b string) bool {
+	normalize := func(s string) string {
+		s = strings.ToLower(s)
+		s = sqlCastRegex.ReplaceAllString(s, "")
+		return sqlSpaceRegex.ReplaceAllString(s, "")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// readOnlyColumns returns name of columns that can't be written on insert and update
+func readOnlyColumns(obj interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return []string{}
+	}
+	cols, _ := tableColumns(obj)
+	res := []string{}
+	for _, col := range cols {
+		if col.IsReadOnly() {
+			res = append(res, col.Name)
+		}
+	}
+	return res
+}
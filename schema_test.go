@@ -0,0 +1,391 @@
+package flexpg
+
+import (
+	"database/sql"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+type schemaTagData struct {
+	ID       string  `json:"id" db_type:"varchar(32)"`
+	Code     string  `db_unique:"true" db_collate:"C" required:"true"`
+	Amount   float64 `db_default:"0" db_check:"amount >= 0" db_comment:"order's amount"`
+	Seq      int     `db_identity:"default"`
+	Total    float64 `db_generated:"amount * 2"`
+	Note     *string
+	Ref      sql.NullInt32
+	Internal string `json:"-"`
+}
+
+func TestCreateTableCommand(t *testing.T) {
+	cv.Convey("create table from struct tags", t, func() {
+		cmds, err := createCommandForCreateTable("orders", []string{"ID"}, new(schemaTagData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(len(cmds), cv.ShouldEqual, 3)
+		cv.So(cmds[0], cv.ShouldEqual, "CREATE TABLE orders ("+
+			"id varchar(32) NOT NULL, "+
+			"code varchar COLLATE \"C\" NOT NULL, "+
+			"amount double precision DEFAULT 0 NOT NULL, "+
+			"seq bigint GENERATED BY DEFAULT AS IDENTITY, "+
+			"total double precision GENERATED ALWAYS AS (amount * 2) STORED NOT NULL, "+
+			"note varchar, "+
+			"ref integer, "+
+			"CONSTRAINT orders_pkey PRIMARY KEY (id), "+
+			"CONSTRAINT orders_code_key UNIQUE (code), "+
+			"CONSTRAINT orders_amount_check CHECK (amount >= 0));")
+		cv.So(cmds[1], cv.ShouldEqual, "COMMENT ON CONSTRAINT orders_code_key ON orders IS 'managed by flexpg'")
+		cv.So(cmds[2], cv.ShouldEqual, "COMMENT ON COLUMN orders.amount IS 'order''s amount'")
+	})
+}
+
+func TestColumnNullability(t *testing.T) {
+	cv.Convey("nullability of columns", t, func() {
+		cols, err := tableColumns(new(schemaTagData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[0].Definition(), cv.ShouldEqual, "id varchar(32) NOT NULL")
+		cv.So(cols[1].Definition(), cv.ShouldEqual, "code varchar COLLATE \"C\" NOT NULL")
+		cv.So(cols[5].Definition(), cv.ShouldEqual, "note varchar")
+		cv.So(cols[6].Definition(), cv.ShouldEqual, "ref integer")
+
+		cv.Convey("existing nullable column is only made NOT NULL when it is allowed", func() {
+			type noteData struct {
+				Note string
+			}
+			meta := tableMeta{Columns: []codekit.M{{"column_name": "note", "udt_name": "varchar", "isnull": "YES"}}}
+			_, err := updateTableCommands("notes", nil, new(noteData), meta, false, false)
+			cv.So(err, cv.ShouldNotBeNil)
+			cv.So(err.Error(), cv.ShouldContainSubstring, "columns note of notes are nullable")
+
+			cmds, err := updateTableCommands("notes", nil, new(noteData), meta, false, true)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(cmds, cv.ShouldResemble, []string{
+				"UPDATE notes SET note = '' WHERE note IS NULL;",
+				"ALTER TABLE notes alter note set not null;",
+			})
+		})
+	})
+}
+
+func TestColumnDefault(t *testing.T) {
+	cv.Convey("default of columns", t, func() {
+		cols, err := tableColumns(new(schemaTagData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[2].Definition(), cv.ShouldEqual, "amount double precision DEFAULT 0 NOT NULL")
+
+		meta := tableMeta{Columns: []codekit.M{
+			{"column_name": "amount", "udt_name": "float8", "isnull": "NO", "column_default": "1"},
+		}}
+		type amountData struct {
+			Amount float64 `db_default:"0"`
+		}
+		cmds, err := updateTableCommands("orders", nil, new(amountData), meta, false, false)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds, cv.ShouldResemble, []string{"ALTER TABLE orders alter amount set default 0;"})
+	})
+}
+
+func TestColumnCheck(t *testing.T) {
+	cv.Convey("check constraint", t, func() {
+		cols, err := tableColumns(new(schemaTagData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[2].Constraints("orders"), cv.ShouldResemble, []string{"CONSTRAINT orders_amount_check CHECK (amount >= 0)"})
+	})
+
+	cv.Convey("comparing stored expression", t, func() {
+		cv.So(sameSQLExpr("'new'", "'new'::character varying"), cv.ShouldBeTrue)
+		cv.So(sameSQLExpr("amount >= 0", "((amount >= (0)::numeric))"), cv.ShouldBeTrue)
+		cv.So(sameSQLExpr("amount > 0", "((amount >= (0)::numeric))"), cv.ShouldBeFalse)
+	})
+}
+
+func TestGeneratedColumn(t *testing.T) {
+	cv.Convey("identity and generated columns", t, func() {
+		cols, err := tableColumns(new(schemaTagData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[3].Definition(), cv.ShouldEqual, "seq bigint GENERATED BY DEFAULT AS IDENTITY")
+		cv.So(cols[4].Definition(), cv.ShouldEqual, "total double precision GENERATED ALWAYS AS (amount * 2) STORED NOT NULL")
+	})
+
+	cv.Convey("read only columns", t, func() {
+		cv.So(readOnlyColumns(schemaTagData{}), cv.ShouldResemble, []string{"total"})
+	})
+}
+
+func TestForeignKey(t *testing.T) {
+	cv.Convey("foreign keys", t, func() {
+		cmds, err := createCommandForCreateTable("sales.orderlines", []string{"OrderID", "Line"}, new(schemaRefData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds[0], cv.ShouldEqual, "CREATE TABLE sales.orderlines ("+
+			"orderid varchar NOT NULL, "+
+			"line bigint NOT NULL, "+
+			"productcode varchar NOT NULL, "+
+			"productvariant varchar NOT NULL, "+
+			"CONSTRAINT orderlines_pk PRIMARY KEY (orderid, line), "+
+			"CONSTRAINT orderlines_product_key UNIQUE (productcode, productvariant), "+
+			"CONSTRAINT orderlines_orderid_fkey FOREIGN KEY (orderid) REFERENCES orders(id) ON DELETE CASCADE DEFERRABLE, "+
+			"CONSTRAINT orderlines_product_fkey FOREIGN KEY (productcode, productvariant) REFERENCES products(code, variant));")
+		cv.So(cmds[1:], cv.ShouldResemble, []string{
+			"COMMENT ON CONSTRAINT orderlines_product_key ON sales.orderlines IS 'managed by flexpg'",
+			"COMMENT ON CONSTRAINT orderlines_orderid_fkey ON sales.orderlines IS 'managed by flexpg'",
+			"COMMENT ON CONSTRAINT orderlines_product_fkey ON sales.orderlines IS 'managed by flexpg'",
+		})
+
+		fk := ForeignKey{Fields: []string{"orderid"}, RefTable: "orders", RefFields: []string{"id"}, OnDelete: "cascade"}
+		cv.So(sameForeignKey(fk, "FOREIGN KEY (orderid) REFERENCES sales.orders(id) ON DELETE CASCADE"), cv.ShouldBeTrue)
+		cv.So(sameForeignKey(fk, "FOREIGN KEY (orderid) REFERENCES orders(id)"), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("updating foreign keys", t, func() {
+		meta := tableMeta{
+			Columns: []codekit.M{
+				{"column_name": "orderid", "udt_name": "varchar", "isnull": "NO"},
+				{"column_name": "line", "udt_name": "int8", "isnull": "NO"},
+				{"column_name": "productcode", "udt_name": "varchar", "isnull": "NO"},
+				{"column_name": "productvariant", "udt_name": "varchar", "isnull": "NO"},
+			},
+			Constraints: []codekit.M{
+				{"conname": "orderlines_pk", "contype": "p", "def": "PRIMARY KEY (orderid, line)"},
+				{"conname": "orderlines_product_key", "contype": "u", "def": "UNIQUE (productcode, productvariant)"},
+				{"conname": "orderlines_orderid_fkey", "contype": "f", "comment": "managed by flexpg",
+					"def": "FOREIGN KEY (orderid) REFERENCES sales.orders(id) ON DELETE CASCADE DEFERRABLE"},
+				{"conname": "orderlines_product_fkey", "contype": "f",
+					"def": "FOREIGN KEY (productcode, productvariant) REFERENCES products(code, variant)"},
+				{"conname": "orderlines_customer_ref", "contype": "f", "comment": "managed by flexpg",
+					"def": "FOREIGN KEY (orderid) REFERENCES customers(id)"},
+				{"conname": "orderlines_manual_fk", "contype": "f",
+					"def": "FOREIGN KEY (orderid) REFERENCES invoices(id)"},
+			},
+		}
+		cmds, err := updateTableCommands("sales.orderlines", []string{"OrderID", "Line"}, new(schemaRefData), meta, false, false)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds, cv.ShouldResemble, []string{
+			"ALTER TABLE sales.orderlines drop constraint orderlines_customer_ref;",
+			"COMMENT ON CONSTRAINT orderlines_product_key ON sales.orderlines IS 'managed by flexpg'",
+			"COMMENT ON CONSTRAINT orderlines_product_fkey ON sales.orderlines IS 'managed by flexpg'",
+		})
+
+		aliasCols, err := tableColumns(new(schemaAliasRefData))
+		cv.So(err, cv.ShouldBeNil)
+		fks := tableForeignKeys(new(schemaAliasRefData), aliasCols)
+		cv.So(fks[0].Definition(), cv.ShouldEqual,
+			"FOREIGN KEY (product_code, product_variant) REFERENCES products(code, variant)")
+
+		fk := newColumnForeignKey("orderid", `db_ref:"Sales.Orders(ID)"`)
+		cv.So(fk.RefTable, cv.ShouldEqual, "sales.orders")
+		cv.So(fk.RefFields, cv.ShouldResemble, []string{"id"})
+	})
+}
+
+func TestTableKeys(t *testing.T) {
+	cv.Convey("updating keys", t, func() {
+		meta := tableMeta{
+			Columns: []codekit.M{
+				{"column_name": "orderid", "udt_name": "varchar", "isnull": "NO"},
+				{"column_name": "line", "udt_name": "int8", "isnull": "NO"},
+				{"column_name": "productcode", "udt_name": "varchar", "isnull": "NO"},
+				{"column_name": "productvariant", "udt_name": "varchar", "isnull": "NO"},
+			},
+			Constraints: []codekit.M{
+				{"conname": "orderlines_pk", "contype": "p", "def": "PRIMARY KEY (orderid)"},
+				{"conname": "orderlines_product_key", "contype": "u", "comment": "managed by flexpg",
+					"def": "UNIQUE (productcode, productvariant)"},
+				{"conname": "orderlines_line_key", "contype": "u", "comment": "managed by flexpg", "def": "UNIQUE (line)"},
+				{"conname": "orderlines_manual_key", "contype": "u", "def": "UNIQUE (orderid, productcode)"},
+				{"conname": "orderlines_orderid_fkey", "contype": "f", "comment": "managed by flexpg",
+					"def": "FOREIGN KEY (orderid) REFERENCES orders(id) ON DELETE CASCADE DEFERRABLE"},
+				{"conname": "orderlines_product_fkey", "contype": "f", "comment": "managed by flexpg",
+					"def": "FOREIGN KEY (productcode, productvariant) REFERENCES products(code, variant)"},
+			},
+		}
+		cmds, err := updateTableCommands("sales.orderlines", []string{"OrderID", "Line"}, new(schemaRefData), meta, false, false)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds, cv.ShouldResemble, []string{
+			"ALTER TABLE sales.orderlines DROP CONSTRAINT orderlines_pk;",
+			"ALTER TABLE sales.orderlines add constraint orderlines_pk PRIMARY KEY (orderid, line),\n" +
+				"drop constraint orderlines_line_key;",
+		})
+
+		meta.PrimaryKeyRefs = []codekit.M{{"conname": "shipments_orderline_fkey", "tablename": "shipments"}}
+		_, err = updateTableCommands("sales.orderlines", []string{"OrderID", "Line"}, new(schemaRefData), meta, false, false)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "shipments.shipments_orderline_fkey")
+	})
+
+	cv.Convey("comparing key definition", t, func() {
+		pk := tableKey{Name: "orders_pkey", Fields: []string{"id", "line"}}
+		cv.So(sameKeyDefinition(pk.PrimaryKeyDefinition(), "PRIMARY KEY (id, line)"), cv.ShouldBeTrue)
+		cv.So(sameKeyDefinition(pk.PrimaryKeyDefinition(), "PRIMARY KEY (id)"), cv.ShouldBeFalse)
+	})
+}
+
+func TestColumnTypeMapping(t *testing.T) {
+	cv.Convey("type mapping", t, func() {
+		type uuid [16]byte
+		type UUID [16]byte
+		type status string
+		cases := []struct {
+			value  interface{}
+			dbType string
+		}{
+			{int(0), "bigint"},
+			{int64(0), "bigint"},
+			{int32(0), "integer"},
+			{uint32(0), "bigint"},
+			{int16(0), "smallint"},
+			{uint64(0), "numeric(20,0)"},
+			{float32(0), "real"},
+			{status(""), "varchar"},
+			{time.Duration(0), "interval"},
+			{&time.Time{}, "timestamptz"},
+			{[]byte{}, "bytea"},
+			{net.IP{}, "inet"},
+			{UUID{}, "uuid"},
+			{uuid{}, "jsonb"},
+			{sql.NullInt64{}, "bigint"},
+			{map[string]string{}, "jsonb"},
+			{schemaStatus(""), "schemastatus"},
+			{schemaPriority(""), "priority"},
+		}
+		for _, c := range cases {
+			cv.So(dbTypeOf(reflect.TypeOf(c.value)), cv.ShouldEqual, c.dbType)
+		}
+
+		q := new(Query)
+		cv.So(q.ValueToSQlValue(status("active")), cv.ShouldEqual, "'active'")
+		cv.So(q.ValueToSQlValue([]byte("ab")), cv.ShouldEqual, "'\\x6162'::bytea")
+		cv.So(q.ValueToSQlValue(90*time.Second), cv.ShouldEqual, "'90000000 microseconds'::interval")
+		cv.So(q.ValueToSQlValue(UUID{0x12, 0x34}), cv.ShouldEqual, "'12340000-0000-0000-0000-000000000000'::uuid")
+	})
+
+	cv.Convey("keeping numeric column of float field", t, func() {
+		type floatData struct {
+			Rate  float64
+			Price float64 `db_precision:"18" db_scale:"2"`
+		}
+		meta := tableMeta{Columns: []codekit.M{
+			{"column_name": "rate", "udt_name": "numeric", "isnull": "NO", "numprecision": 64, "numscale": 8},
+			{"column_name": "price", "udt_name": "numeric", "isnull": "NO", "numprecision": 64, "numscale": 8},
+		}}
+		cmds, err := updateTableCommands("rates", nil, new(floatData), meta, true, false)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds, cv.ShouldResemble, []string{
+			"ALTER TABLE rates alter price type numeric(18,2) using price::numeric(18,2);"})
+	})
+
+	cv.Convey("embedded struct", t, func() {
+		cols, err := tableColumns(new(schemaEmbeddedData))
+		cv.So(err, cv.ShouldBeNil)
+		names := []string{}
+		for _, col := range cols {
+			names = append(names, col.Name+" "+col.Type)
+		}
+		cv.So(names, cv.ShouldResemble, []string{
+			"id varchar", "created timestamptz", "title varchar", "schemaaudit jsonb"})
+
+		data := schemaEmbeddedData{Title: "embedded"}
+		data.ID = "e1"
+		cv.So(flattenStruct(data), cv.ShouldResemble, codekit.M{
+			"id": "e1", "created": time.Time{}, "title": "embedded", "schemaaudit": SchemaAudit{}})
+		cv.So(flattenStruct(SchemaAudit{}), cv.ShouldResemble, SchemaAudit{})
+	})
+}
+
+func TestEnumColumn(t *testing.T) {
+	cv.Convey("enum", t, func() {
+		cols, err := tableColumns(new(schemaEnumData))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[0].Type, cv.ShouldEqual, "schemastatus")
+		cv.So(cols[0].Enum, cv.ShouldResemble, &enumType{Name: "schemastatus", Values: []string{"open", "closed"}})
+		cv.So(cols[1].Type, cv.ShouldEqual, "schemastatus[]")
+		cv.So(cols[2].Enum, cv.ShouldBeNil)
+		cv.So(enumLabels([]string{"it's", "ok"}), cv.ShouldEqual, "'it''s', 'ok'")
+		cv.So(columnFillValue(cols[0]), cv.ShouldEqual, "'open'::schemastatus")
+		cv.So(columnFillValue(cols[1]), cv.ShouldEqual, "'{}'")
+		cv.So(columnFillValue(cols[2]), cv.ShouldEqual, "''")
+
+		meta := tableMeta{Columns: []codekit.M{
+			{"column_name": "history", "udt_name": "_schemastatus", "isnull": "NO"},
+			{"column_name": "legacy", "udt_name": "schemastatus", "isnull": "YES"},
+		}}
+		type enumData struct {
+			Status schemaStatus
+			Legacy schemaStatus
+		}
+		cmds, err := updateTableCommands("tickets", nil, new(enumData), meta, false, true)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cmds, cv.ShouldResemble, []string{
+			"UPDATE tickets SET legacy = 'open'::schemastatus WHERE legacy IS NULL;",
+			"ALTER TABLE tickets add status schemastatus DEFAULT 'open'::schemastatus NOT NULL,\nalter legacy set not null;",
+			"ALTER TABLE tickets ALTER status DROP DEFAULT;",
+			"alter table tickets drop column history",
+		})
+	})
+}
+
+type schemaRefData struct {
+	OrderID        string `db_ref:"orders" db_ondelete:"cascade" db_deferrable:"true"`
+	Line           int
+	ProductCode    string `db_unique:"product"`
+	ProductVariant string `db_unique:"product"`
+}
+
+func (schemaRefData) PrimaryKeyName() string {
+	return "orderlines_pk"
+}
+
+func (schemaRefData) ForeignKeys() []ForeignKey {
+	return []ForeignKey{
+		{Name: "orderlines_product_fkey", Fields: []string{"ProductCode", "ProductVariant"}, RefTable: "products", RefFields: []string{"code", "variant"}},
+	}
+}
+
+type schemaAliasRefData struct {
+	Code    string `json:"product_code"`
+	Variant string `json:"product_variant"`
+}
+
+func (schemaAliasRefData) ForeignKeys() []ForeignKey {
+	return []ForeignKey{{Fields: []string{"Code", "product_variant"}, RefTable: "Products", RefFields: []string{"code", "variant"}}}
+}
+
+type schemaBaseModel struct {
+	ID      string
+	Created time.Time
+}
+
+type SchemaAudit struct {
+	By string
+}
+
+type schemaEmbeddedData struct {
+	schemaBaseModel
+	Title       string
+	SchemaAudit `db_json:"true"`
+}
+
+type schemaStatus string
+
+func (schemaStatus) EnumValues() []string {
+	return []string{"open", "closed"}
+}
+
+type schemaPriority string
+
+func (*schemaPriority) EnumValues() []string {
+	return []string{"low", "high"}
+}
+
+func (*schemaPriority) EnumTypeName() string {
+	return "Priority"
+}
+
+type schemaEnumData struct {
+	Status  schemaStatus
+	History []schemaStatus `db_array:"true"`
+	Legacy  schemaStatus   `db_type:"varchar(10)"`
+}
@@ -0,0 +1,180 @@
+package flexpg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/sebarcode/codekit"
+)
+
+// ConfigKeyStream is key of Cursor input to read its rows through server side cursor by batch, so memory usage is
+// bounded by the batch size regardless size of the result. Its value is the batch size, or true to use
+// DefaultStreamBatchSize, ie: conn.Cursor(cmd, codekit.M{flexpg.ConfigKeyStream: 500}).
+// Rows of streaming cursor are fetched into struct or map only
+const ConfigKeyStream = "stream"
+
+// DefaultStreamBatchSize is number of rows fetched by each FETCH of a stream when batch size is not given
+const DefaultStreamBatchSize = 1000
+
+var streamSeq uint64
+
+// serverCursor is a cursor declared on the server, its rows are read by FETCH. It lives in the transaction of
+// the connection, or in a new transaction which is ended when it is closed
+type serverCursor struct {
+	tx        *sql.Tx
+	ownTx     bool
+	name      string
+	batchSize int
+	done      bool
+}
+
+// streamBatchSize returns batch size of ConfigKeyStream input, it returns false when the cursor is not streamed
+func streamBatchSize(in codekit.M) (int, bool) {
+	v, ok := in[ConfigKeyStream]
+	if !ok || v == nil {
+		return 0, false
+	}
+	if stream, isBool := v.(bool); isBool {
+		return DefaultStreamBatchSize, stream
+	}
+	if batchSize := in.GetInt(ConfigKeyStream); batchSize > 0 {
+		return batchSize, true
+	}
+	return DefaultStreamBatchSize, true
+}
+
+// declareCursor declares server side cursor of a select command
+func (c *Connection) declareCursor(cmdtxt string, batchSize int) (*serverCursor, error) {
+	sc := &serverCursor{
+		name:      fmt.Sprintf("flexpg_stream_%d", atomic.AddUint64(&streamSeq, 1)),
+		batchSize: batchSize,
+	}
+	if c.IsTx() {
+		sc.tx = c.tx
+	} else {
+		var err error
+		if sc.tx, err = c.db.Begin(); err != nil {
+			return nil, err
+		}
+		sc.ownTx = true
+	}
+
+	declare := fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", sc.name, cmdtxt)
+	dbflex.Logger().Debugf("execute command: %s", declare)
+	if _, err := sc.tx.Exec(declare); err != nil {
+		if sc.ownTx {
+			sc.tx.Rollback()
+		}
+		return nil, fmt.Errorf("%s. SQL Command: %s", err.Error(), declare)
+	}
+	return sc, nil
+}
+
+// fetch reads next batch of rows
+func (sc *serverCursor) fetch() (*sql.Rows, error) {
+	cmdtxt := fmt.Sprintf("FETCH %d FROM %s", sc.batchSize, sc.name)
+	dbflex.Logger().Debugf("execute command: %s", cmdtxt)
+	rows, err := sc.tx.Query(cmdtxt)
+	if err != nil {
+		return nil, fmt.Errorf("%s. SQL Command: %s", err.Error(), cmdtxt)
+	}
+	return rows, nil
+}
+
+// close closes the cursor and ends the transaction created for it
+func (sc *serverCursor) close() error {
+	if sc.tx == nil {
+		return nil
+	}
+	_, err := sc.tx.Exec("CLOSE " + sc.name)
+	if sc.ownTx {
+		if err != nil {
+			sc.tx.Rollback()
+		} else {
+			err = sc.tx.Commit()
+		}
+	}
+	sc.tx = nil
+	return err
+}
+
+// Stream reads rows of a streaming cursor one by one
+type Stream struct {
+	cursor *Cursor
+	err    error
+}
+
+// Stream opens cursor of a select command with ConfigKeyStream and returns Stream to read its rows one by one
+func (c *Connection) Stream(cmd dbflex.ICommand, batchSize int) (*Stream, error) {
+	if c.db == nil {
+		return nil, errors.New("connection is not opened")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultStreamBatchSize
+	}
+
+	cur := c.Cursor(cmd, codekit.M{ConfigKeyStream: batchSize})
+	if err := cur.Error(); err != nil {
+		cur.Close()
+		return nil, err
+	}
+	cursor, ok := cur.(*Cursor)
+	if !ok || cursor.stream == nil {
+		cur.Close()
+		return nil, errors.New("unable to open streaming cursor")
+	}
+	return &Stream{cursor: cursor}, nil
+}
+
+// Next decodes next row into dest, which is pointer of a struct or codekit.M. It returns false when there is no more
+// row or an error occurs, check Err to differentiate them
+func (s *Stream) Next(dest interface{}) bool {
+	if s.err != nil {
+		return false
+	}
+	destValue := reflect.ValueOf(dest)
+	if !isRowDestination(destValue.Type()) {
+		s.err = errors.New("destination should be pointer of a struct or a map")
+		return false
+	}
+
+	var hasRow bool
+	if hasRow, s.err = s.cursor.nextRow(); !hasRow {
+		return false
+	}
+	s.err = s.cursor.scanRow(destValue)
+	return s.err == nil
+}
+
+// Each calls fn for each row of the stream, newItem returns a new destination for each row, ie:
+//
+//	stream.Each(func() interface{} { return new(Audit) }, func(v interface{}) error { ... })
+//
+// Each stops at the first error returned by fn. The stream is closed when Each returns
+func (s *Stream) Each(newItem func() interface{}, fn func(interface{}) error) error {
+	defer s.Close()
+	for {
+		item := newItem()
+		if !s.Next(item) {
+			break
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// Err returns error occurred while reading the stream
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close closes the server side cursor and ends the transaction created by the stream
+func (s *Stream) Close() error {
+	return s.cursor.Close()
+}
@@ -0,0 +1,32 @@
+package flexpg
+
+import (
+	"testing"
+
+	"github.com/sebarcode/codekit"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreamBatchSize(t *testing.T) {
+	cv.Convey("reading stream input of cursor", t, func() {
+		cases := []struct {
+			in        codekit.M
+			batchSize int
+			stream    bool
+		}{
+			{nil, 0, false},
+			{codekit.M{}, 0, false},
+			{codekit.M{ConfigKeyStream: false}, DefaultStreamBatchSize, false},
+			{codekit.M{ConfigKeyStream: true}, DefaultStreamBatchSize, true},
+			{codekit.M{ConfigKeyStream: 200}, 200, true},
+			{codekit.M{ConfigKeyStream: 0}, DefaultStreamBatchSize, true},
+		}
+		for _, c := range cases {
+			batchSize, stream := streamBatchSize(c.in)
+			cv.So(stream, cv.ShouldEqual, c.stream)
+			if stream {
+				cv.So(batchSize, cv.ShouldEqual, c.batchSize)
+			}
+		}
+	})
+}
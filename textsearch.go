@@ -0,0 +1,109 @@
+package flexpg
+
+import (
+	"fmt"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// TSVector is type of full text search column. Field of this type tagged with db_tsvector:"title,body" is created
+// by EnsureTable as generated column of the listed columns, db_tsconfig tag sets its text search configuration
+type TSVector string
+
+// DefaultTSConfig is text search configuration used when it is not given
+const DefaultTSConfig = "simple"
+
+// Columns added to the result of Connection.Search
+const (
+	SearchRankColumn     = "search_rank"
+	SearchHeadlineColumn = "search_headline"
+)
+
+// tsVectorExpr returns expression of generated tsvector column of given columns
+func tsVectorExpr(config string, columns []string) string {
+	if config == "" {
+		config = DefaultTSConfig
+	}
+	items := make([]string, len(columns))
+	for i, column := range columns {
+		items[i] = fmt.Sprintf("coalesce(%s, '')", strings.ToLower(strings.TrimSpace(column)))
+	}
+	return fmt.Sprintf("to_tsvector('%s'::regconfig, %s)", CleanupSQL(config), strings.Join(items, " || ' ' || "))
+}
+
+// TextSearch is full text search on a tsvector column using web search syntax, ie: "coffee -decaf"
+type TextSearch struct {
+	Field  string
+	Query  string
+	Config string
+	// Headline is column which snippet of matching text is returned as search_headline, it is optional
+	Headline        string
+	HeadlineOptions string
+}
+
+func (ts TextSearch) tsQuery() string {
+	config := ts.Config
+	if config == "" {
+		config = DefaultTSConfig
+	}
+	return fmt.Sprintf("websearch_to_tsquery('%s'::regconfig, '%s')", CleanupSQL(config), CleanupSQL(ts.Query))
+}
+
+// Filter creates filter field @@ websearch_to_tsquery(config, query)
+func (ts TextSearch) Filter() *dbflex.Filter {
+	return &dbflex.Filter{Field: ts.Field, Op: OpTextSearch, Value: ts}
+}
+
+// RankExpr returns ts_rank expression of the search
+func (ts TextSearch) RankExpr() string {
+	return fmt.Sprintf("ts_rank(%s, %s)", ts.Field, ts.tsQuery())
+}
+
+// HeadlineExpr returns ts_headline expression of the search, it is empty if Headline is not set
+func (ts TextSearch) HeadlineExpr() string {
+	if ts.Headline == "" {
+		return ""
+	}
+	config := ts.Config
+	if config == "" {
+		config = DefaultTSConfig
+	}
+	args := []string{fmt.Sprintf("'%s'::regconfig", CleanupSQL(config)), ts.Headline, ts.tsQuery()}
+	if ts.HeadlineOptions != "" {
+		args = append(args, fmt.Sprintf("'%s'", CleanupSQL(ts.HeadlineOptions)))
+	}
+	return fmt.Sprintf("ts_headline(%s)", strings.Join(args, ", "))
+}
+
+// searchCommand returns select command of the search ordered by its rank
+func (ts TextSearch) searchCommand(tableName string, where string, take, skip int) string {
+	fields := []string{"*", ts.RankExpr() + " AS " + SearchRankColumn}
+	if headline := ts.HeadlineExpr(); headline != "" {
+		fields = append(fields, headline+" AS "+SearchHeadlineColumn)
+	}
+	cmdtxt := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s DESC", strings.Join(fields, ", "), tableName, where, SearchRankColumn)
+	if take > 0 {
+		cmdtxt += fmt.Sprintf(" LIMIT %d", take)
+	}
+	if skip > 0 {
+		cmdtxt += fmt.Sprintf(" OFFSET %d", skip)
+	}
+	return cmdtxt
+}
+
+// Search reads rows of a table matching the text search into dest ordered by rank, the most relevant first.
+// Rank and headline are returned as search_rank and search_headline column. Filter is optional
+func (c *Connection) Search(tableName string, ts TextSearch, filter *dbflex.Filter, take, skip int, dest interface{}) error {
+	where := ts.Filter()
+	if filter != nil {
+		where = dbflex.And(where, filter)
+	}
+	q := c.NewQuery().(*Query)
+	whereSQL, err := q.BuildFilter(where)
+	if err != nil {
+		return err
+	}
+	cmdtxt := ts.searchCommand(tableName, fmt.Sprintf("%v", whereSQL), take, skip)
+	return c.Cursor(dbflex.SQL(cmdtxt), nil).Fetchs(dest, 0).Close()
+}
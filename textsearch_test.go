@@ -0,0 +1,38 @@
+package flexpg
+
+import (
+	"testing"
+
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTextSearch(t *testing.T) {
+	cv.Convey("tsvector column", t, func() {
+		type article struct {
+			ID     string
+			Title  string
+			Body   string
+			Search TSVector `db_tsvector:"Title, Body" db_tsconfig:"english"`
+		}
+		cols, err := tableColumns(article{})
+		cv.So(err, cv.ShouldBeNil)
+		search := cols[3]
+		cv.So(search.Type, cv.ShouldEqual, "tsvector")
+		cv.So(search.IsReadOnly(), cv.ShouldBeTrue)
+		cv.So(search.Definition(), cv.ShouldEqual,
+			"search tsvector GENERATED ALWAYS AS (to_tsvector('english'::regconfig, coalesce(title, '') || ' ' || coalesce(body, ''))) STORED NOT NULL")
+
+		cv.Convey("search query", func() {
+			ts := TextSearch{Field: "search", Query: "coffee -decaf it's", Config: "english", Headline: "body"}
+			q := new(Query)
+			where, err := q.BuildFilter(ts.Filter())
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(where, cv.ShouldEqual, "search @@ websearch_to_tsquery('english'::regconfig, 'coffee -decaf it''s')")
+
+			cv.So(ts.searchCommand("articles", "x = 1", 10, 20), cv.ShouldEqual,
+				"SELECT *, ts_rank(search, websearch_to_tsquery('english'::regconfig, 'coffee -decaf it''s')) AS search_rank, "+
+					"ts_headline('english'::regconfig, body, websearch_to_tsquery('english'::regconfig, 'coffee -decaf it''s')) AS search_headline "+
+					"FROM articles WHERE x = 1 ORDER BY search_rank DESC LIMIT 10 OFFSET 20")
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package flexpg
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampLayout is used to write time value, offset has seconds since historical zone offset might have it
+const timestampLayout = "2006-01-02 15:04:05.999999-07:00:00"
+
+// timeLayouts are output formats of timestamptz, timestamp, date and time columns, tried in order
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999-07:00:00",
+	"15:04:05.999999999-07:00",
+	"15:04:05.999999999-07",
+	"15:04:05.999999999",
+}
+
+// parseTime parses time text returned by database. Value without offset is read as UTC, the same way lib/pq reads
+// timestamp column
+func parseTime(str string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if dt, err := time.Parse(layout, str); err == nil {
+			return dt, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse %s as time", str)
+}
+
+// timeInLocation converts time value into location of database session
+func timeInLocation(v interface{}, location *time.Location) interface{} {
+	switch dt := v.(type) {
+	case time.Time:
+		return dt.In(location)
+	case *time.Time:
+		if dt == nil {
+			return dt
+		}
+		inLocation := dt.In(location)
+		return &inLocation
+	}
+	return v
+}
@@ -0,0 +1,210 @@
+package flexpg
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TypeMapping maps a Go type into PostgreSQL type. ToSQL and FromDB are optional, ToSQL writes value as SQL literal
+// and FromDB converts value returned by database driver into the Go type
+type TypeMapping struct {
+	DBType string
+	ToSQL  func(v interface{}) string
+	FromDB func(v interface{}) (interface{}, error)
+}
+
+var (
+	typeMappings   = map[reflect.Type]TypeMapping{}
+	typeMappingsMu sync.RWMutex
+
+	// typeNameMappings maps well known types of other libraries by its name, so the driver doesn't need to import them
+	typeNameMappings = map[string]TypeMapping{
+		"uuid.UUID":       {DBType: "uuid", ToSQL: uuidToSQL},
+		"decimal.Decimal": {DBType: "numeric", ToSQL: stringerToSQL},
+	}
+
+	// kindDBTypes maps Go kinds into PostgreSQL types, int is 64 bit so it is bigint. float32 and float64 were mapped
+	// to numeric(32,8) and numeric(64,8) before, EnsureTable keeps such existing column
+	kindDBTypes = map[reflect.Kind]string{
+		reflect.String:  "varchar",
+		reflect.Bool:    "boolean",
+		reflect.Int:     "bigint",
+		reflect.Int8:    "smallint",
+		reflect.Int16:   "smallint",
+		reflect.Int32:   "integer",
+		reflect.Int64:   "bigint",
+		reflect.Uint8:   "smallint",
+		reflect.Uint16:  "integer",
+		reflect.Uint32:  "bigint",
+		reflect.Uint:    "numeric(20,0)",
+		reflect.Uint64:  "numeric(20,0)",
+		reflect.Float32: "real",
+		reflect.Float64: "double precision",
+	}
+)
+
+func init() {
+	RegisterType(reflect.TypeOf(time.Time{}), TypeMapping{DBType: "timestamptz"})
+	RegisterType(reflect.TypeOf(time.Duration(0)), TypeMapping{DBType: "interval", ToSQL: durationToSQL})
+	RegisterType(reflect.TypeOf([]byte{}), TypeMapping{DBType: "bytea", ToSQL: bytesToSQL})
+	RegisterType(reflect.TypeOf(json.RawMessage{}), TypeMapping{DBType: "jsonb", ToSQL: rawJSONToSQL})
+	RegisterType(reflect.TypeOf(net.IP{}), TypeMapping{DBType: "inet", ToSQL: stringerToSQL})
+	RegisterType(reflect.TypeOf(net.IPNet{}), TypeMapping{DBType: "cidr", ToSQL: stringerToSQL})
+	RegisterType(reflect.TypeOf(TSVector("")), TypeMapping{DBType: "tsvector"})
+	RegisterType(reflect.TypeOf(Decimal("")), TypeMapping{DBType: "numeric", ToSQL: decimalToSQL, FromDB: decimalFromDB})
+	RegisterType(reflect.TypeOf(big.Int{}), TypeMapping{DBType: "numeric", ToSQL: bigIntToSQL, FromDB: bigIntFromDB})
+	RegisterType(reflect.TypeOf(big.Float{}), TypeMapping{DBType: "numeric", ToSQL: bigFloatToSQL, FromDB: bigFloatFromDB})
+	RegisterType(reflect.TypeOf(big.Rat{}), TypeMapping{DBType: "numeric", ToSQL: bigRatToSQL, FromDB: bigRatFromDB})
+}
+
+// RegisterType registers mapping of a Go type, it is used by EnsureTable to decide column type, and by query and
+// cursor to write and read the value. Registering already registered type replaces its mapping
+func RegisterType(t reflect.Type, mapping TypeMapping) {
+	typeMappingsMu.Lock()
+	defer typeMappingsMu.Unlock()
+	typeMappings[t] = mapping
+}
+
+// lookupTypeMapping returns mapping of registered or well known type
+func lookupTypeMapping(t reflect.Type) (TypeMapping, bool) {
+	if t == nil {
+		return TypeMapping{}, false
+	}
+	typeMappingsMu.RLock()
+	mapping, ok := typeMappings[t]
+	typeMappingsMu.RUnlock()
+	if ok {
+		return mapping, true
+	}
+
+	if mapping, ok = typeNameMappings[t.String()]; ok {
+		return mapping, true
+	}
+	if t.Name() == "UUID" && t.Kind() == reflect.Array && t.Len() == 16 {
+		return typeNameMappings["uuid.UUID"], true
+	}
+	return TypeMapping{}, false
+}
+
+// dbTypeOf returns PostgreSQL type of a Go type, pointer and sql.Null* types are resolved to type of its value
+func dbTypeOf(t reflect.Type) string {
+	t = indirectType(t)
+	t, _ = sqlNullValueType(t)
+	if mapping, ok := lookupTypeMapping(t); ok {
+		return mapping.DBType
+	}
+	if et := enumOf(t); et != nil {
+		return et.Name
+	}
+	if dbType, ok := kindDBTypes[t.Kind()]; ok {
+		return dbType
+	}
+	return "jsonb"
+}
+
+// registeredFromDB converts value using FromDB of type mapping of refType, or of its element when refType is a pointer.
+// Result is adjusted to be a pointer or not following refType. It returns false if there is no FromDB
+func registeredFromDB(value interface{}, refType reflect.Type) (interface{}, bool, error) {
+	mapping, ok := lookupTypeMapping(refType)
+	if !ok && refType != nil && refType.Kind() == reflect.Ptr {
+		mapping, ok = lookupTypeMapping(refType.Elem())
+	}
+	if !ok || mapping.FromDB == nil {
+		return nil, false, nil
+	}
+
+	v, err := mapping.FromDB(value)
+	if err != nil || v == nil {
+		return v, true, err
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type() == refType:
+	case rv.Kind() == reflect.Ptr && rv.Type().Elem() == refType:
+		v = rv.Elem().Interface()
+	case refType.Kind() == reflect.Ptr && rv.Type() == refType.Elem():
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		v = ptr.Interface()
+	}
+	return v, true, nil
+}
+
+// registeredSQLValue writes value using ToSQL of its type mapping, returns false if there is none.
+// Pointer is dereferenced before its mapping is looked up
+func registeredSQLValue(v interface{}) (string, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		v = rv.Elem().Interface()
+	}
+	mapping, ok := lookupTypeMapping(reflect.TypeOf(v))
+	if !ok || mapping.ToSQL == nil {
+		return "", false
+	}
+	return mapping.ToSQL(v), true
+}
+
+// kindSQLValue writes value of named types based on its kind, ie: type Status string
+func kindSQLValue(v interface{}) (string, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("'%s'", CleanupSQL(rv.String())), true
+	case reflect.Bool:
+		return fmt.Sprintf("%t", rv.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%d", rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d", rv.Uint()), true
+	}
+	return "", false
+}
+
+// stringerToSQL writes value as string literal, String method with pointer receiver is also used
+func stringerToSQL(v interface{}) string {
+	if _, ok := v.(fmt.Stringer); !ok {
+		rv := reflect.ValueOf(v)
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		if stringer, ok := ptr.Interface().(fmt.Stringer); ok {
+			v = stringer
+		}
+	}
+	return fmt.Sprintf("'%s'", CleanupSQL(fmt.Sprintf("%v", v)))
+}
+
+func durationToSQL(v interface{}) string {
+	return fmt.Sprintf("'%d microseconds'::interval", v.(time.Duration).Microseconds())
+}
+
+func bytesToSQL(v interface{}) string {
+	return fmt.Sprintf("'\\x%s'::bytea", hex.EncodeToString(v.([]byte)))
+}
+
+func rawJSONToSQL(v interface{}) string {
+	raw := v.(json.RawMessage)
+	if len(raw) == 0 {
+		return "'null'::jsonb"
+	}
+	return fmt.Sprintf("'%s'::jsonb", CleanupSQL(string(raw)))
+}
+
+func uuidToSQL(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Array || rv.Len() != 16 {
+		return stringerToSQL(v)
+	}
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(rv.Index(i).Uint())
+	}
+	s := hex.EncodeToString(b)
+	return fmt.Sprintf("'%s'::uuid", strings.Join([]string{s[:8], s[8:12], s[12:16], s[16:20], s[20:]}, "-"))
+}
@@ -0,0 +1,75 @@
+package flexpg
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+var (
+	valuerInterface  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerInterface = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// asValuer returns driver.Valuer of a value, including when Value method has pointer receiver
+func asValuer(v interface{}) (driver.Valuer, bool) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		return valuer, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Invalid || rv.Kind() == reflect.Ptr || !reflect.PtrTo(rv.Type()).Implements(valuerInterface) {
+		return nil, false
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	return ptr.Interface().(driver.Valuer), true
+}
+
+// valuerSQLValue writes driver.Valuer using value it returns, which is one of driver.Value types. []byte is written as
+// string literal as lib/pq does, since valuers mostly return it for json and text, not for bytea
+func (qr *Query) valuerSQLValue(v interface{}) (string, bool) {
+	valuer, ok := asValuer(v)
+	if !ok {
+		return "", false
+	}
+	value, err := valuer.Value()
+	if err != nil {
+		if qr.valueErr == nil {
+			qr.valueErr = err
+		}
+		return "NULL", true
+	}
+	switch value := value.(type) {
+	case nil:
+		return "NULL", true
+	case []byte:
+		return fmt.Sprintf("'%s'", CleanupSQL(string(value))), true
+	case driver.Valuer:
+		return "", false
+	}
+	return qr.ValueToSQlValue(value), true
+}
+
+// isScanner returns true if pointer of the type implements sql.Scanner
+func isScanner(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		return t.Implements(scannerInterface)
+	}
+	return reflect.PtrTo(t).Implements(scannerInterface)
+}
+
+// scanValue converts value returned by database driver into a sql.Scanner type using its Scan method
+func scanValue(value interface{}, refType reflect.Type) (interface{}, error) {
+	ptr := reflect.New(indirectType(refType))
+	if err := ptr.Interface().(sql.Scanner).Scan(value); err != nil {
+		return nil, err
+	}
+	if refType.Kind() == reflect.Ptr {
+		return ptr.Interface(), nil
+	}
+	return ptr.Elem().Interface(), nil
+}
@@ -0,0 +1,120 @@
+package flexpg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// ErrVersionConflict is returned by update of a struct with version field when the row has been changed by other
+// update, or it has been deleted. Struct with version field should be written as pointer, so its version field is set
+// with the written version
+var ErrVersionConflict = errors.New("version conflict: row has been changed by other update")
+
+// rowVersion is version of a struct being written, its field is tagged with db_version:"true"
+type rowVersion struct {
+	col   columnDef
+	value int64
+	// field is set with the new version after successful write
+	field reflect.Value
+}
+
+// newRowVersion returns version of data, nil if data is not a struct with version field. Data with version field
+// should be pointer of struct, otherwise the written version could not be set back
+func newRowVersion(data interface{}) (*rowVersion, error) {
+	v := reflect.ValueOf(data)
+	isPtr := v.Kind() == reflect.Ptr
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	cols, err := tableColumns(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range cols {
+		if !col.Version {
+			continue
+		}
+		if !isPtr {
+			return nil, fmt.Errorf("data with version field %s should be pointer of %s", col.FieldName, v.Type().Name())
+		}
+		field := fieldByIndexAlloc(v, col.Index)
+		if !field.IsValid() {
+			return nil, fmt.Errorf("version field %s is promoted through nil pointer of unexported struct", col.FieldName)
+		}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		default:
+			return nil, fmt.Errorf("version field %s should be an integer", col.FieldName)
+		}
+		return &rowVersion{col: col, value: field.Int(), field: field}, nil
+	}
+	return nil, nil
+}
+
+// apply writes version into fields of insert and update command. Insert writes 1 for zero version, update increments
+// the version
+func (rv *rowVersion) apply(cmdtype string, fieldnames, values []string) ([]string, []string) {
+	idx := -1
+	for i, field := range fieldnames {
+		if strings.EqualFold(field, rv.col.Name) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		fieldnames = append(fieldnames, rv.col.Name)
+		values = append(values, strconv.FormatInt(rv.value, 10))
+		idx = len(fieldnames) - 1
+	}
+
+	switch cmdtype {
+	case dbflex.QueryInsert:
+		if rv.value == 0 {
+			values[idx] = "1"
+		}
+
+	case dbflex.QueryUpdate:
+		values[idx] = rv.col.Name + "+1"
+	}
+	return fieldnames, values
+}
+
+// filter adds condition matching the version being updated into filter of update command
+func (rv *rowVersion) filter(f *dbflex.Filter) *dbflex.Filter {
+	return dbflex.And(f, dbflex.Eq(rv.col.Name, rv.value))
+}
+
+// updateCommand builds update command of which filter only matches the row which version is still the same
+func (q *Query) updateCommand(rv *rowVersion) (string, error) {
+	// version alone would match every row of the same version
+	filter, _ := q.Config(dbflex.ConfigKeyFilter, nil).(*dbflex.Filter)
+	if filter == nil {
+		return "", errors.New("update of data with version field should have filter, ie: filter by its key")
+	}
+	tablename, _ := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	if tablename == "" {
+		return "", errors.New("update of data with version field should have table name")
+	}
+	where, err := q.BuildFilter(rv.filter(filter))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("UPDATE %s SET {{.FIELDVALUES}} WHERE %v", tablename, where), nil
+}
+
+// written sets version field with the version written by the command
+func (rv *rowVersion) written(cmdtype string) {
+	switch cmdtype {
+	case dbflex.QueryInsert:
+		if rv.value == 0 {
+			rv.field.SetInt(1)
+		}
+	case dbflex.QueryUpdate:
+		rv.field.SetInt(rv.value + 1)
+	}
+}
@@ -0,0 +1,102 @@
+package flexpg
+
+import (
+	"testing"
+
+	"git.kanosolution.net/kano/dbflex"
+	cv "github.com/smartystreets/goconvey/convey"
+)
+
+type versionedOrder struct {
+	ID      string
+	Amount  int
+	Version int64 `db_version:"true"`
+}
+
+// Versioned is embedded by pointer in version test
+type Versioned struct {
+	Version int64 `db_version:"true"`
+}
+
+func TestVersion(t *testing.T) {
+	cv.Convey("version column", t, func() {
+		cols, err := tableColumns(versionedOrder{})
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(cols[2].Definition(), cv.ShouldEqual, "version bigint DEFAULT 1 NOT NULL")
+
+		cv.Convey("update matches and increments version", func() {
+			order := &versionedOrder{ID: "o1", Amount: 10, Version: 3}
+			rv, err := newRowVersion(order)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(rv, cv.ShouldNotBeNil)
+
+			fields, values := rv.apply(dbflex.QueryUpdate, []string{"id", "amount", "version"}, []string{"'o1'", "10", "3"})
+			cv.So(fields, cv.ShouldResemble, []string{"id", "amount", "version"})
+			cv.So(values, cv.ShouldResemble, []string{"'o1'", "10", "version+1"})
+
+			fields, values = rv.apply(dbflex.QueryUpdate, []string{"amount"}, []string{"10"})
+			cv.So(fields, cv.ShouldResemble, []string{"amount", "version"})
+			cv.So(values, cv.ShouldResemble, []string{"10", "version+1"})
+
+			where := dbflex.Eq("id", "o1")
+			cv.So(rv.filter(where), cv.ShouldResemble, dbflex.And(where, dbflex.Eq("version", int64(3))))
+
+			rv.written(dbflex.QueryUpdate)
+			cv.So(order.Version, cv.ShouldEqual, 4)
+		})
+
+		cv.Convey("insert starts from 1", func() {
+			order := &versionedOrder{ID: "o2"}
+			rv, err := newRowVersion(order)
+			cv.So(err, cv.ShouldBeNil)
+			_, values := rv.apply(dbflex.QueryInsert, []string{"id", "amount", "version"}, []string{"'o2'", "0", "0"})
+			cv.So(values[2], cv.ShouldEqual, "1")
+			rv.written(dbflex.QueryInsert)
+			cv.So(order.Version, cv.ShouldEqual, 1)
+		})
+
+		cv.Convey("struct with version is not pointer", func() {
+			_, err := newRowVersion(versionedOrder{ID: "o3"})
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+
+		cv.Convey("version field promoted through embedded pointer", func() {
+			type versioned struct {
+				Version int64 `db_version:"true"`
+			}
+			// Version of the outer struct shadows the promoted version field
+			type shadowing struct {
+				ID string
+				*Versioned
+				Version string `json:"apiversion"`
+			}
+			data := &shadowing{ID: "o4", Version: "v2"}
+			rv, err := newRowVersion(data)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(rv, cv.ShouldNotBeNil)
+			cv.So(data.Versioned, cv.ShouldNotBeNil)
+			rv.written(dbflex.QueryInsert)
+			cv.So(data.Versioned.Version, cv.ShouldEqual, 1)
+
+			_, err = newRowVersion(&struct {
+				ID string
+				*versioned
+			}{ID: "o5"})
+			cv.So(err, cv.ShouldNotBeNil)
+		})
+
+		cv.Convey("update without filter", func() {
+			rv, err := newRowVersion(&versionedOrder{ID: "o6", Version: 2})
+			cv.So(err, cv.ShouldBeNil)
+			_, err = new(Query).updateCommand(rv)
+			cv.So(err, cv.ShouldNotBeNil)
+			cv.So(err.Error(), cv.ShouldContainSubstring, "should have filter")
+		})
+
+		cv.Convey("struct without version", func() {
+			rv, err := newRowVersion(struct{ ID string }{})
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(rv, cv.ShouldBeNil)
+		})
+	})
+}